@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"io"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+)
+
+func newConfigCmd(logger logr.Logger, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with chart configuration files",
+		Long:  ``,
+	}
+
+	cmd.AddCommand(
+		newConfigMigrateCmd(logger, out),
+		newConfigSchemaCmd(logger, out),
+		newConfigValidateCmd(logger, out),
+	)
+
+	return cmd
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	cfg "github.com/yeahdongcn/kustohelmize/pkg/config"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/cmd/helm/require"
+)
+
+const configMigrateDesc = `
+Migrate the configuration file of a chart (<chart>.config) in place, keeping
+the original file as <chart>.config.bak.
+
+Files in an older format are upgraded to the current apiVersion. Files without
+an apiVersion lose the unused logger property, and the deprecated condition and
+conditionValue properties are converted to conditions.
+
+fileConfig entries keyed by intermediate file path, such as
+mychart-generated/nginx-deployment.yaml, are rewritten to be keyed by the
+identity of the resource (apiVersion/kind/namespace/name), such as
+apps/v1/Deployment//nginx. Identity keys keep working when the chart or the
+intermediate directory is moved. Entries of multi-document files are split per
+resource, and their $N. XPath prefixes are dropped. This requires the
+intermediate directory of the chart, so run 'create' first.
+`
+
+type configMigrateOptions struct {
+	options
+}
+
+func newConfigMigrateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
+	o := &configMigrateOptions{
+		options: options{
+			logger: logger.WithName("migrate"),
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "migrate NAME",
+		Short: "Migrate the configuration file of a chart in place",
+		Long:  configMigrateDesc,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = args[0]
+			if o.intermediateDir == "" {
+				o.intermediateDir = fmt.Sprintf("%s-%s", o.name, "generated")
+			}
+
+			return o.run(out)
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.intermediateDir, "intermediate-dir", "i", "", "The path to a intermediate directory")
+	cmd.Flags().MarkHidden("intermediate-dir")
+
+	return cmd
+}
+
+func (o *configMigrateOptions) run(out io.Writer) error {
+	path := o.configPath()
+	original, err := os.ReadFile(path)
+	if err != nil {
+		o.logger.Error(err, "Error reading config file", "path", path)
+		return err
+	}
+	config, changes, err := cfg.Migrate(o.logger.WithName("config"), path)
+	if err != nil {
+		o.logger.Error(err, "Error migrating config file", "path", path)
+		return err
+	}
+	for _, change := range changes {
+		fmt.Fprintf(out, "Migrated: %s\n", change)
+	}
+
+	if _, err := os.Stat(o.intermediateDir); err == nil {
+		err = config.IndexManifests(o.intermediateDir)
+		if err != nil {
+			o.logger.Error(err, "Error indexing intermediate files", "dir", o.intermediateDir)
+			return err
+		}
+		for _, skipped := range config.MigrateFileConfigKeys() {
+			fmt.Fprintf(out, "Not migrated: %s\n", skipped)
+		}
+	} else {
+		fmt.Fprintf(out, "Not migrated: fileConfig keys, intermediate directory '%s' not found\n", o.intermediateDir)
+	}
+
+	output, err := yaml.Marshal(config)
+	if err != nil {
+		o.logger.Error(err, "Error marshalling config file")
+		return err
+	}
+	if bytes.Equal(output, original) {
+		fmt.Fprintf(out, "%s is up to date\n", path)
+		return nil
+	}
+	backup := path + ".bak"
+	err = os.WriteFile(backup, original, 0644)
+	if err != nil {
+		o.logger.Error(err, "Error writing backup file", "path", backup)
+		return err
+	}
+	err = os.WriteFile(path, output, 0644)
+	if err != nil {
+		o.logger.Error(err, "Error writing config file", "path", path)
+		return err
+	}
+	fmt.Fprintf(out, "Migrated %s, the original file is kept as %s\n", path, backup)
+	return nil
+}
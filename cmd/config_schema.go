@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"io"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	cfg "github.com/yeahdongcn/kustohelmize/pkg/config"
+	"helm.sh/helm/v3/cmd/helm/require"
+)
+
+const configSchemaDesc = `
+Print the JSON Schema of the chart configuration file (<chart>.config).
+
+Editors can use the schema to validate and complete configuration files, e.g.
+with the YAML extension of VS Code:
+
+    kustohelmize config schema > kustohelmize.schema.json
+
+    "yaml.schemas": {
+        "./kustohelmize.schema.json": "*.config"
+    }
+`
+
+type configSchemaOptions struct {
+	logger logr.Logger
+}
+
+func newConfigSchemaCmd(logger logr.Logger, out io.Writer) *cobra.Command {
+	o := &configSchemaOptions{
+		logger: logger.WithName("schema"),
+	}
+
+	cmd := &cobra.Command{
+		Use:               "schema",
+		Short:             "Print the JSON Schema of the chart configuration file",
+		Long:              configSchemaDesc,
+		Args:              require.NoArgs,
+		ValidArgsFunction: noCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(out)
+		},
+	}
+
+	return cmd
+}
+
+func (o *configSchemaOptions) run(out io.Writer) error {
+	schema, err := cfg.JSONSchema()
+	if err != nil {
+		o.logger.Error(err, "Error generating JSON Schema")
+		return err
+	}
+	_, err = out.Write(schema)
+	return err
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	cfg "github.com/yeahdongcn/kustohelmize/pkg/config"
+	"helm.sh/helm/v3/cmd/helm/require"
+)
+
+const configValidateDesc = `
+Validate one or more chart configuration files (<chart>.config).
+
+Every problem is reported with the file, line and column it was found at, e.g.
+
+    mychart.config:13:17: 'apps/v1/Deployment//nginx' unknown strategy 'inlne' at 'spec.replicas', did you mean 'inline'?
+
+The command exits with a non-zero status if any problem is found.
+`
+
+type configValidateOptions struct {
+	logger logr.Logger
+}
+
+func newConfigValidateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
+	o := &configValidateOptions{
+		logger: logger.WithName("validate"),
+	}
+
+	cmd := &cobra.Command{
+		Use:   "validate FILE...",
+		Short: "Validate chart configuration files",
+		Long:  configValidateDesc,
+		Args:  require.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return []string{"config"}, cobra.ShellCompDirectiveFilterFileExt
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(out, args)
+		},
+	}
+
+	return cmd
+}
+
+func (o *configValidateOptions) run(out io.Writer, paths []string) error {
+	problems := 0
+	for _, path := range paths {
+		diagnostics, err := cfg.ValidateFile(path)
+		if err != nil {
+			o.logger.Error(err, "Error reading config file", "path", path)
+			return err
+		}
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(out, diagnostic)
+		}
+		problems += len(diagnostics)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s) in %d config file(s)", problems, len(paths))
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/yeahdongcn/kustohelmize/internal/third_party/dep/fs"
 	cfg "github.com/yeahdongcn/kustohelmize/pkg/config"
+	"github.com/yeahdongcn/kustohelmize/pkg/manifest"
 	"github.com/yeahdongcn/kustohelmize/pkg/template"
 	"github.com/yeahdongcn/kustohelmize/pkg/value"
 	"gopkg.in/yaml.v2"
@@ -38,9 +39,14 @@ type createOptions struct {
 	appVersion  string
 	description string
 
-	from                       string
+	in                         io.Reader
+	from                       []string
+	kustomize                  string
 	kubernetesSplitYamlCommand string
 	suppressNamespace          bool
+	preserveKeyOrder           bool
+	presets                    []string
+	infer                      bool
 
 	// From helm.
 	starter    string // --starter
@@ -70,6 +76,7 @@ func newCreateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			o.name = args[0]
+			o.in = cmd.InOrStdin()
 			o.starterDir = helmpath.DataPath("starters")
 			if o.intermediateDir == "" {
 				o.intermediateDir = fmt.Sprintf("%s-%s", o.name, "generated")
@@ -77,11 +84,16 @@ func newCreateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
 			if o.enableIntermediateDirCleanup {
 				defer os.RemoveAll(o.intermediateDir)
 			}
-			if err := o.prepare(); err != nil {
+			config, exists, err := o.getConfig()
+			if err != nil {
+				o.logger.Error(err, "Error getting config")
+				return err
+			}
+			if err := o.prepare(config); err != nil {
 				return err
 			}
 
-			return o.run(out)
+			return o.run(out, config, !exists)
 		},
 	}
 
@@ -89,10 +101,14 @@ func newCreateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
 	cmd.Flags().StringVarP(&o.appVersion, "app-version", "a", "", "The version of the application enclosed inside of this chart")
 	cmd.Flags().StringVarP(&o.description, "description", "d", "", "A one-sentence description of the chart")
 
-	cmd.Flags().StringVarP(&o.from, "from", "f", "", "The path to a Kubernetes manifest YAML file")
-	cmd.MarkFlagRequired("from")
-	cmd.Flags().StringVarP(&o.kubernetesSplitYamlCommand, "kubernetes-split-yaml-command", "k", "kubernetes-split-yaml", "Command to split Kubernetes YAML")
+	cmd.Flags().StringArrayVarP(&o.from, "from", "f", nil, "The path to a Kubernetes manifest YAML or JSON file, a directory or a glob pattern, or - to read from stdin (can be repeated)")
+	cmd.Flags().StringVarP(&o.kustomize, "kustomize", "", "", "The path to a kustomization directory to build in-process")
+	cmd.MarkFlagsOneRequired("from", "kustomize")
+	cmd.MarkFlagsMutuallyExclusive("from", "kustomize")
+	cmd.Flags().StringVarP(&o.kubernetesSplitYamlCommand, "kubernetes-split-yaml-command", "k", "", "Command to split Kubernetes YAML instead of the built-in splitter")
+	cmd.MarkFlagsMutuallyExclusive("kustomize", "kubernetes-split-yaml-command")
 	cmd.Flags().BoolVarP(&o.suppressNamespace, "suppress-namespace", "s", false, "Suppress creation of namespace resource, which Kustomize will emit. RBAC bindings for SAs will be to {{ .Release.Namespace }}")
+	cmd.Flags().BoolVarP(&o.preserveKeyOrder, "preserve-key-order", "", false, "Keep the keys of the generated templates in the order of the source manifests instead of sorting them")
 	cmd.Flags().StringVarP(&o.intermediateDir, "intermediate-dir", "i", "", "The path to a intermediate directory")
 	cmd.Flags().MarkHidden("intermediate-dir")
 	cmd.Flags().BoolVarP(&o.enableIntermediateDirCleanup, "cleanup", "", false, "Whether to cleanup the intermediate directory")
@@ -100,6 +116,9 @@ func newCreateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
 	cmd.Flags().StringVarP(&o.config, "config", "c", "", "The path to a config file")
 	cmd.Flags().MarkHidden("config")
 
+	cmd.Flags().BoolVarP(&o.infer, "infer", "", false, "Add rules inferred from the manifests for images, replicas, ports, env and resources to the config file, marked for review")
+	cmd.Flags().StringSliceVarP(&o.presets, "preset", "", nil, fmt.Sprintf("Built-in rules to add to the config file for the matching resources: %s (can be repeated)", strings.Join(cfg.PresetNames(), ", ")))
+
 	cmd.Flags().StringVarP(&o.starter, "starter", "p", "", "The name or absolute path to Helm starter scaffold")
 	return cmd
 }
@@ -107,16 +126,45 @@ func newCreateCmd(logger logr.Logger, out io.Writer) *cobra.Command {
 func (o *createOptions) updateConfig(config *cfg.ChartConfig, forceSave bool) error {
 	shouldSave := false
 
-	c, _ := os.ReadDir(o.intermediateDir)
-	for _, entry := range c {
-		if _, ok := config.FileConfig[filepath.Join(o.intermediateDir, entry.Name())]; !ok {
-			config.FileConfig[filepath.Join(o.intermediateDir, entry.Name())] = cfg.Config{}
+	err := config.IndexManifests(o.intermediateDir)
+	if err != nil {
+		o.logger.Error(err, "Error indexing intermediate files", "dir", o.intermediateDir)
+		return err
+	}
+	// New resources are keyed by identity, unless their file is already configured by path.
+	for _, identity := range config.Identities() {
+		path, _ := config.ManifestPath(identity)
+		_, hasPath := config.FileConfig[path]
+		_, hasIdentity := config.FileConfig[identity]
+		if !hasPath && !hasIdentity {
+			config.FileConfig[identity] = cfg.Config{}
 			if !forceSave {
 				shouldSave = true
 			}
 		}
 	}
 
+	changed, err := config.ApplyPresets(o.presets)
+	if err != nil {
+		o.logger.Error(err, "Error applying presets", "presets", o.presets)
+		return err
+	}
+	if changed {
+		shouldSave = true
+	}
+
+	if o.infer {
+		changed, err := config.Infer()
+		if err != nil {
+			o.logger.Error(err, "Error inferring config")
+			return err
+		}
+		if changed {
+			o.logger.Info("Added inferred rules to the config file, review the entries marked as inferred", "path", o.configPath())
+			shouldSave = true
+		}
+	}
+
 	if shouldSave || forceSave {
 		output, err := yaml.Marshal(config)
 		if err != nil {
@@ -129,44 +177,92 @@ func (o *createOptions) updateConfig(config *cfg.ChartConfig, forceSave bool) er
 	return nil
 }
 
-func (o *createOptions) getConfig() (*cfg.ChartConfig, error) {
+// Load the existing config file, or create a new one. The returned bool reports whether the file exists.
+func (o *createOptions) getConfig() (*cfg.ChartConfig, bool, error) {
 	path := o.configPath()
 	logger := o.logger.WithName("config")
 	_, err := os.Stat(path)
 	if err == nil {
 		o.logger.Info("Config file already exists", "path", path)
 
-		out, err := os.ReadFile(path)
+		config, err := cfg.Load(logger, path)
 		if err != nil {
-			o.logger.Error(err, "Error reading config file", "path", path)
-			return nil, err
-		}
-		config := &cfg.ChartConfig{Logger: logger}
-		err = yaml.Unmarshal(out, config)
-		if err != nil {
-			o.logger.Error(err, "Error unmarshalling config file", "path", path)
-			return nil, err
-		}
-		err = o.updateConfig(config, false)
-		if err != nil {
-			o.logger.Error(err, "Error updating config file", "path", path)
-			return nil, err
+			o.logger.Error(err, "Error loading config file", "path", path)
+			return nil, true, err
 		}
 		err = config.Validate()
 		if err != nil {
 			o.logger.Error(err, "Error validating config file", "path", path)
-			return nil, err
+			return nil, true, err
 		}
-		return config, nil
+		return config, true, nil
 	}
 
 	chartname := o.chartname()
 	config := cfg.NewChartConfig(logger, chartname)
-	err = o.updateConfig(config, true)
-	return config, err
+	return config, false, nil
+}
+
+func (o *createOptions) prepare(config *cfg.ChartConfig) error {
+	if o.kubernetesSplitYamlCommand != "" {
+		if config.NamingTemplate != "" {
+			o.logger.Info("Ignoring naming template with external split command", "command", o.kubernetesSplitYamlCommand)
+		}
+		return o.prepareWithCommand()
+	}
+
+	namer, err := manifest.NewNamer(config.NamingTemplate)
+	if err != nil {
+		o.logger.Error(err, "Error parsing naming template", "template", config.NamingTemplate)
+		return err
+	}
+
+	resources, err := o.readResources()
+	if err != nil {
+		return err
+	}
+	paths, err := manifest.Write(o.intermediateDir, resources, namer)
+	if err != nil {
+		o.logger.Error(err, "Error writing intermediate files", "dir", o.intermediateDir)
+		return err
+	}
+	for _, path := range paths {
+		o.logger.V(10).Info("Created intermediate file", "path", path)
+	}
+	return nil
 }
 
-func (o *createOptions) prepare() error {
+func (o *createOptions) readResources() ([]*manifest.Resource, error) {
+	if o.kustomize != "" {
+		resources, err := manifest.Kustomize(o.kustomize)
+		if err != nil {
+			o.logger.Error(err, "Error building kustomization", "dir", o.kustomize)
+			return nil, err
+		}
+		return resources, nil
+	}
+
+	sources, err := manifest.Expand(o.from)
+	if err != nil {
+		o.logger.Error(err, "Error resolving manifest sources", "from", o.from)
+		return nil, err
+	}
+	resources, err := manifest.ReadAll(sources, o.in)
+	if err != nil {
+		o.logger.Error(err, "Error splitting manifests", "sources", sources)
+		return nil, err
+	}
+	return resources, nil
+}
+
+// Split the manifests with an external kubernetes-split-yaml compatible command.
+func (o *createOptions) prepareWithCommand() error {
+	sources, err := manifest.Expand(o.from)
+	if err != nil {
+		o.logger.Error(err, "Error resolving manifest sources", "from", o.from)
+		return err
+	}
+
 	var path string
 
 	if fs.IsAbsolutePath(o.kubernetesSplitYamlCommand) {
@@ -182,20 +278,42 @@ func (o *createOptions) prepare() error {
 
 		path = filepath.Join(filepath.Dir(e), o.kubernetesSplitYamlCommand)
 	}
-	_, err := exec.Command(path, "--outdir", o.intermediateDir, o.from).CombinedOutput()
-	if err != nil {
-		o.logger.Error(err, fmt.Sprintf("Error running %s", path))
+	if err := manifest.RemoveManifests(o.intermediateDir); err != nil {
+		o.logger.Error(err, "Error removing intermediate files", "dir", o.intermediateDir)
 		return err
 	}
+	for _, source := range sources {
+		c := exec.Command(path, "--outdir", o.intermediateDir, source)
+		if source == manifest.Stdin {
+			c.Stdin = o.in
+		}
+		output, err := c.CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("%s failed on '%s': %w\n%s", path, source, err, strings.TrimSpace(string(output)))
+			o.logger.Error(err, fmt.Sprintf("Error running %s", path))
+			return err
+		}
+	}
 	return nil
 }
 
-func (o *createOptions) run(out io.Writer) error {
+func (o *createOptions) run(out io.Writer, config *cfg.ChartConfig, forceSave bool) error {
 	o.logger.Info("Creating chart", "name", o.name)
 
-	config, err := o.getConfig()
+	err := o.updateConfig(config, forceSave)
+	if err != nil {
+		o.logger.Error(err, "Error updating config file", "path", o.configPath())
+		return err
+	}
+	// The included files are merged after updating, so that they are not written to the config file.
+	config, err = config.WithIncludes()
+	if err != nil {
+		o.logger.Error(err, "Error including config files", "path", o.configPath())
+		return err
+	}
+	err = config.Validate()
 	if err != nil {
-		o.logger.Error(err, "Error getting config")
+		o.logger.Error(err, "Error validating included config files", "path", o.configPath())
 		return err
 	}
 
@@ -302,7 +420,8 @@ func (o *createOptions) run(out io.Writer) error {
 		WithChartConfig(config).
 		WithTemplatesDir(filepath.Join(chartdir, chartutil.TemplatesDir)).
 		WithCrdsDir(filepath.Join(chartdir, "crds")).
-		WithSuppressNamespace(o.suppressNamespace)
+		WithSuppressNamespace(o.suppressNamespace).
+		WithPreserveKeyOrder(o.preserveKeyOrder)
 
 	err = p.Process()
 	if err != nil {
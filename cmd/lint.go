@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	cfg "github.com/yeahdongcn/kustohelmize/pkg/config"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+const lintDesc = `
+Check the configuration of a chart against its intermediate files, which are written
+by create, and report the rules that have no effect:
+
+- XPaths that match no node of the resources they apply to
+- strategies applied to a node they cannot template, e.g. control-range on a scalar
+- sharedValues that are never referenced, by the configuration or by the helpers of the chart
+
+The globalConfig rules and empty sharedValues that create adds to every configuration are
+not reported until they are changed.
+
+The configuration is checked with the files it includes merged in, and every problem is
+reported with the file, line and column of the rule or value it was found at, e.g.
+
+    mychart.config:13:5: 'apps/v1/Deployment//nginx' XPath 'spec.replicas' matches no node
+
+Configurations of files that no longer exist are reported by purge instead.
+The command exits with a non-zero status if any problem is found.
+`
+
+// Values referred to by templates, e.g. .Values.serviceAccount.create.
+var valuesReferenceRegex = regexp.MustCompile(`\.Values\.([A-Za-z0-9_]+(?:\.[A-Za-z0-9_]+)*)`)
+
+type lintOptions struct {
+	options
+}
+
+func newLintCmd(logger logr.Logger, out io.Writer) *cobra.Command {
+	o := &lintOptions{
+		options: options{
+			logger: logger.WithName("lint"),
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "lint NAME",
+		Short: "Report configuration rules that have no effect",
+		Long:  lintDesc,
+		Args:  require.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				// Allow file completion when completing the argument for the name
+				// which could be a path
+				return nil, cobra.ShellCompDirectiveDefault
+			}
+			// No more completions, so disable file completion
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			o.name = args[0]
+			if o.intermediateDir == "" {
+				o.intermediateDir = fmt.Sprintf("%s-%s", o.name, "generated")
+			}
+
+			return o.run(out)
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.intermediateDir, "intermediate-dir", "i", "", "The path to a intermediate directory")
+	cmd.Flags().MarkHidden("intermediate-dir")
+
+	return cmd
+}
+
+func (o *lintOptions) run(out io.Writer) error {
+	path := o.configPath()
+	o.logger.Info("Linting chart", "name", o.name, "config", path)
+
+	if _, err := os.Stat(o.intermediateDir); err != nil {
+		o.logger.Error(err, "Intermediate directory not found, run create first", "dir", o.intermediateDir)
+		return err
+	}
+
+	config, err := cfg.Load(o.logger.WithName("config"), path)
+	if err != nil {
+		o.logger.Error(err, "Error loading config file", "path", path)
+		return err
+	}
+
+	err = config.IndexManifests(o.intermediateDir)
+	if err != nil {
+		o.logger.Error(err, "Error indexing intermediate files", "dir", o.intermediateDir)
+		return err
+	}
+
+	config, err = config.WithIncludes()
+	if err != nil {
+		o.logger.Error(err, "Error including config files", "path", path)
+		return err
+	}
+
+	helpers := []string{}
+	bs, err := os.ReadFile(filepath.Join(o.name, chartutil.HelpersName))
+	if err != nil && !os.IsNotExist(err) {
+		o.logger.Error(err, "Error reading helpers", "chart", o.name)
+		return err
+	}
+	for _, m := range valuesReferenceRegex.FindAllStringSubmatch(string(bs), -1) {
+		helpers = append(helpers, m[1])
+	}
+
+	diagnostics, err := config.Lint(helpers)
+	if err != nil {
+		o.logger.Error(err, "Error linting config file", "path", path)
+		return err
+	}
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintln(out, diagnostic)
+	}
+
+	if len(diagnostics) > 0 {
+		return fmt.Errorf("found %d problem(s) in %s", len(diagnostics), path)
+	}
+	o.logger.Info("No problems found")
+	return nil
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/spf13/cobra"
 	cfg "github.com/yeahdongcn/kustohelmize/pkg/config"
-	"gopkg.in/yaml.v2"
 	"helm.sh/helm/v3/cmd/helm/require"
 )
 
@@ -70,15 +69,15 @@ func (o *purgeOptions) purgeConfig() ([]string, error) {
 		return nil, err
 	}
 
-	out, err := os.ReadFile(path)
+	config, err := cfg.Load(logger, path)
 	if err != nil {
-		o.logger.Error(err, "Error reading config file", "path", path)
+		o.logger.Error(err, "Error loading config file", "path", path)
 		return nil, err
 	}
-	config := &cfg.ChartConfig{Logger: logger}
-	err = yaml.Unmarshal(out, config)
+
+	err = config.IndexManifests(o.intermediateDir)
 	if err != nil {
-		o.logger.Error(err, "Error unmarshalling config file", "path", path)
+		o.logger.Error(err, "Error indexing intermediate files", "dir", o.intermediateDir)
 		return nil, err
 	}
 
@@ -88,10 +87,16 @@ func (o *purgeOptions) purgeConfig() ([]string, error) {
 		names[entry.Name()] = struct{}{}
 	}
 	redundancies := make([]string, 0)
-	for path := range config.FileConfig {
+	for key := range config.FileConfig {
+		path, ok := config.ManifestPath(key)
+		if !ok {
+			// The identity of a resource that is gone
+			redundancies = append(redundancies, key)
+			continue
+		}
 		name := filepath.Base(path)
 		if _, ok := names[name]; !ok {
-			redundancies = append(redundancies, path)
+			redundancies = append(redundancies, key)
 		}
 	}
 
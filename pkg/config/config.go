@@ -1,8 +1,10 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/dlclark/regexp2"
@@ -42,6 +44,26 @@ const (
 	XPathStrategyAppendWith    XPathStrategy = "append-with"
 )
 
+// XPathStrategies lists every supported strategy.
+var XPathStrategies = []XPathStrategy{
+	XPathStrategyInline,
+	XPathStrategyInlineYAML,
+	XPathStrategyNewline,
+	XPathStrategyNewlineYAML,
+	XPathStrategyControlIf,
+	XPathStrategyControlIfYAML,
+	XPathStrategyControlWith,
+	XPathStrategyControlRange,
+	XPathStrategyFileIf,
+	XPathStrategyInlineRegex,
+	XPathStrategyAppendWith,
+}
+
+const (
+	ConditionOperatorAnd = "and"
+	ConditionOperatorOr  = "or"
+)
+
 type Condition struct {
 	Key   string `yaml:"key,omitempty"`
 	Value bool   `yaml:"value,omitempty"`
@@ -56,7 +78,9 @@ type XPathConfig struct {
 	RegexCompiled     *regexp2.Regexp `yaml:"-"`
 	Conditions        []Condition     `yaml:"conditions,omitempty"`
 	ConditionOperator *string         `yaml:"conditionOperator,omitempty"`
-	// Deprecated
+	// Inferred marks configurations added by create --infer that have not been reviewed yet.
+	Inferred bool `yaml:"inferred,omitempty"`
+	// Deprecated: use Conditions. Load converts them, and config migrate rewrites the file.
 	Condition      string `yaml:"condition,omitempty"`
 	ConditionValue bool   `yaml:"conditionValue,omitempty"`
 }
@@ -92,14 +116,44 @@ func (xpath XPath) NewElement(sliceIndex int) XPath {
 	return XPath(fmt.Sprintf("%s[%d]", xpath, sliceIndex))
 }
 
+// NewChild returns the XPath of the key s of the map at xpath, or of the element at sliceIndex
+// of the list at xpath. Keys such as prometheus.io/scrape are quoted, as in ["prometheus.io/scrape"].
 func (xpath XPath) NewChild(s string, sliceIndex int) XPath {
-	if xpath.IsRoot() {
+	parent := xpath.NewElement(sliceIndex)
+	if needsQuote(s) {
+		return XPath(fmt.Sprintf("%s[%s]", parent, strconv.Quote(s)))
+	}
+	if parent.IsRoot() {
 		return XPath(s)
 	}
-	if sliceIndex == XPathSliceIndexNone {
-		return XPath(fmt.Sprintf("%s.%s", xpath, s))
+	return XPath(fmt.Sprintf("%s.%s", parent, s))
+}
+
+// InDocument scopes xpath to the document at index within a multi-document file.
+// The first document is not prefixed, so single document files keep their plain XPaths.
+func (xpath XPath) InDocument(index int) XPath {
+	if index == 0 {
+		return xpath
+	}
+	prefix := fmt.Sprintf("%s%d", XPathDocumentPrefix, index)
+	if xpath.IsRoot() {
+		return XPath(prefix)
+	}
+	return XPath(prefix + XPathSeparator + string(xpath))
+}
+
+// Document splits xpath into the document index and the XPath within that document.
+func (xpath XPath) Document() (int, XPath) {
+	s := string(xpath)
+	if !strings.HasPrefix(s, XPathDocumentPrefix) {
+		return 0, xpath
+	}
+	prefix, local, _ := strings.Cut(strings.TrimPrefix(s, XPathDocumentPrefix), XPathSeparator)
+	index, err := strconv.Atoi(prefix)
+	if err != nil || index < 0 {
+		return 0, xpath
 	}
-	return XPath(fmt.Sprintf("%s[%d].%s", xpath, sliceIndex, s))
+	return index, XPath(local)
 }
 
 type Config map[XPath]XPathConfigs
@@ -134,12 +188,40 @@ func defaultSharedValues() GenericMap {
 	}
 }
 
+// Report whether a value of sharedValues is missing or empty, as those seeded by defaultSharedValues are.
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(v) == 0
+	}
+	m, ok := sharedMap(value)
+	return ok && len(m) == 0
+}
+
 type ChartConfig struct {
-	Logger       logr.Logger
-	Chartname    string            `yaml:"chartname"`
-	SharedValues GenericMap        `yaml:"sharedValues"`
-	GlobalConfig Config            `yaml:"globalConfig"`
-	FileConfig   map[string]Config `yaml:"fileConfig"`
+	Logger logr.Logger `yaml:"-"`
+	// APIVersion is the format of the configuration file, see Migrate.
+	APIVersion string `yaml:"apiVersion"`
+	Chartname  string `yaml:"chartname"`
+	// Include lists configuration files to merge into this one, see WithIncludes.
+	Include []string `yaml:"include,omitempty"`
+	// NamingTemplate derives intermediate and template file names from resources, see manifest.Namer.
+	NamingTemplate string     `yaml:"namingTemplate,omitempty"`
+	SharedValues   GenericMap `yaml:"sharedValues"`
+	GlobalConfig   Config     `yaml:"globalConfig"`
+	// SelectorConfig applies to the resources matching each selector, see Selector.
+	SelectorConfig []SelectorConfig  `yaml:"selectorConfig,omitempty"`
+	FileConfig     map[string]Config `yaml:"fileConfig"`
+
+	// The file the configuration was loaded from, which included files are relative to.
+	path string
+	// The configurations merged by WithIncludes, in the order they were merged.
+	sources []*ChartConfig
+	// Resources of the intermediate files, see IndexManifests.
+	identities map[string]manifestRef
+	documents  map[string][]string
 }
 
 type kvPair struct {
@@ -150,6 +232,7 @@ type kvPair struct {
 func NewChartConfig(logger logr.Logger, chartname string) *ChartConfig {
 	config := &ChartConfig{
 		Logger:       logger,
+		APIVersion:   APIVersion,
 		Chartname:    chartname,
 		SharedValues: defaultSharedValues(),
 		GlobalConfig: defaultGlobalConfig(chartname),
@@ -184,10 +267,10 @@ func sortConfigKeys(config Config) []XPath {
 	return configKeys
 }
 
+// Values returns the content of values.yaml. Every configuration whose values cannot be added is
+// reported, with the fileConfig entry or the resource, the XPath and the index of the rule.
 func (cc *ChartConfig) Values() (string, error) {
 	str := ""
-	var err error
-	var out []byte
 	// 1. SharedValues
 	if len(cc.SharedValues) > 0 {
 		out, err := yaml.Marshal(cc.SharedValues)
@@ -197,84 +280,149 @@ func (cc *ChartConfig) Values() (string, error) {
 		str += fmt.Sprintf("%s\n", string(out))
 	}
 
-	// 2. FileConfig
+	// 2. FileConfig and SelectorConfig
+	var errs []error
 	root := GenericMap{}
-	for filename, fileConfig := range cc.FileConfig {
+	// Memoize values seen at various XPaths, per values prefix. The first value wins, so
+	// identity entries come before path entries, and fileConfig comes before selectorConfig.
+	rememberedValues := map[string]map[string]interface{}{}
+	addValues := func(filename string, section string, config Config) {
 		key := util.LowerCamelFilenameWithoutExt(filename)
-		root[key] = GenericMap{}
-		fileRoot := root[key].(GenericMap)
+		// A file may be configured by its path, by the identities of its resources and by selectors.
+		if _, ok := root[key]; !ok {
+			root[key] = GenericMap{}
+			rememberedValues[key] = map[string]interface{}{}
+		}
+		for _, err := range cc.addValues(root[key].(GenericMap), config, rememberedValues[key]) {
+			errs = append(errs, fmt.Errorf("%s: %w", section, err))
+		}
+	}
 
-		// Memoize values seen at various XPaths
-		rememberedValues := make(map[string]interface{})
+	filenames := sortedKeys(cc.FileConfig)
+	sort.SliceStable(filenames, func(i, j int) bool { return !cc.isPathKey(filenames[i]) && cc.isPathKey(filenames[j]) })
+	for _, filename := range filenames {
+		path := filename
+		if p, ok := cc.ManifestPath(filename); ok {
+			path = p
+		}
+		fileConfig, err := cc.expandFileConfig(filename, cc.FileConfig[filename])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addValues(path, fmt.Sprintf("%s '%s'", sectionFileConfig, filename), fileConfig)
+	}
+	for _, identity := range cc.Identities() {
+		ref := cc.identities[identity]
+		selected := cc.selectedConfig(ref.resource)
+		fileConfig := cc.fileDocumentConfig(ref.path, ref.document)
+		// Both are expanded, so that their XPaths are canonical and compare equal however they are
+		// written, and the selected XPaths that match no node of the resource are left out.
+		node := resourceNode(ref.resource)
+		var err error
+		if selected, err = ExpandSharedConfig(selected, node); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", sectionSelectorConfig, identity, err))
+		}
+		selected = matchingConfig(selected, node)
+		// Errors of fileConfig have been reported above.
+		fileConfig, _ = ExpandConfig(fileConfig, node)
+		// XPaths configured by fileConfig do not use the selectors.
+		for xpath := range fileConfig {
+			delete(selected, xpath)
+		}
+		if len(selected) > 0 {
+			addValues(ref.path, fmt.Sprintf("%s: %s", sectionSelectorConfig, identity), selected)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return str, err
+	}
+	out, err := yaml.Marshal(root)
+	if err != nil {
+		return str, err
+	}
 
-		// Order each fileConfig by whether or not any of its strategies have values
-		for _, xpath := range sortConfigKeys(fileConfig) {
-			for _, c := range fileConfig[xpath] {
-				configRoot := fileRoot
+	str += fmt.Sprintf("%s\n", string(out))
+	return str, nil
+}
 
-				kvs := []kvPair{{c.Key, c.Value}}
-				if c.Condition != "" {
-					conditionKey := strings.TrimPrefix(c.Condition, "!")
-					kvs = append(kvs, kvPair{conditionKey, c.ConditionValue})
-				}
-				for _, condition := range c.Conditions {
-					conditionKey := strings.TrimPrefix(condition.Key, "!")
-					kvs = append(kvs, kvPair{conditionKey, condition.Value})
+// Add the values of config to fileRoot. A values key that would replace the value of another key
+// is reported instead.
+func (cc *ChartConfig) addValues(fileRoot GenericMap, config Config, rememberedValues map[string]interface{}) []error {
+	var errs []error
+	// Order each fileConfig by whether or not any of its strategies have values
+	for _, xpath := range sortConfigKeys(config) {
+		for index, c := range config[xpath] {
+			configRoot := fileRoot
+
+			kvs := []kvPair{{c.Key, c.Value}}
+			if c.Condition != "" {
+				conditionKey := strings.TrimPrefix(c.Condition, "!")
+				kvs = append(kvs, kvPair{conditionKey, c.ConditionValue})
+			}
+			for _, condition := range c.Conditions {
+				conditionKey := strings.TrimPrefix(condition.Key, "!")
+				kvs = append(kvs, kvPair{conditionKey, condition.Value})
+			}
+		keys:
+			for _, kv := range kvs {
+				substrings := keyPath(kv.Key)
+				if _, ok := rememberedValues[kv.Key]; !ok {
+					// Init rememberedValues for this key
+					rememberedValues[kv.Key] = nil
 				}
-				for _, kv := range kvs {
-					substrings := strings.Split(kv.Key, XPathSeparator)
-					if _, ok := rememberedValues[kv.Key]; !ok {
-						// Init rememberedValues for this key
-						rememberedValues[kv.Key] = nil
+				for i, substring := range substrings {
+					// XXX: For shared values and global defined values, we should not extend values.yaml
+					if i == 0 && (substring == sharedValuesPrefix || substring == cc.Chartname || substring == "") {
+						break
 					}
-					for i, substring := range substrings {
-						// XXX: For shared values and global defined values, we should not extend values.yaml
-						if i == 0 && (substring == sharedValuesPrefix || substring == cc.Chartname || substring == "") {
-							break
-						}
-						if i == 1 && substring == "Chart" {
-							break
+					if i == 1 && substring == "Chart" {
+						break
+					}
+					if configRoot[substring] == nil {
+						configRoot[substring] = GenericMap{}
+					}
+					if i < len(substrings)-1 {
+						next, ok := configRoot[substring].(GenericMap)
+						if !ok {
+							errs = append(errs, fmt.Errorf("XPath '%s' rule %d: key '%s' conflicts with the value of '%s'", xpath, index, kv.Key, strings.Join(substrings[:i+1], XPathSeparator)))
+							continue keys
 						}
-						if configRoot[substring] == nil {
-							configRoot[substring] = GenericMap{}
+						configRoot = next
+					} else {
+						previousValue, ok := rememberedValues[kv.Key]
+						if configRoot[substring] != nil && ok && previousValue != nil {
+							kv.Value = previousValue
 						}
-						if i < len(substrings)-1 {
-							configRoot = configRoot[substring].(GenericMap)
+						if kv.Value == nil {
+							cc.Logger.Info(fmt.Sprintf("%s: %s", kv.Key, "nil"))
+							delete(configRoot, substring)
 						} else {
-							previousValue, ok := rememberedValues[kv.Key]
-							if configRoot[substring] != nil && ok && previousValue != nil {
-								kv.Value = previousValue
-							}
-							if kv.Value == nil {
-								cc.Logger.Info(fmt.Sprintf("%s: %s", kv.Key, "nil"))
-								delete(configRoot, substring)
-							} else {
-								rememberedValues[kv.Key] = kv.Value
-								switch v := kv.Value.(type) {
-								case int:
-									cc.Logger.V(10).Info("type int", "key", kv.Key, "value", v)
-									configRoot[substring] = v
-								case string:
-									cc.Logger.V(10).Info("type string", "key", kv.Key, "value", v)
+							rememberedValues[kv.Key] = kv.Value
+							switch v := kv.Value.(type) {
+							case int:
+								cc.Logger.V(10).Info("type int", "key", kv.Key, "value", v)
+								configRoot[substring] = v
+							case string:
+								cc.Logger.V(10).Info("type string", "key", kv.Key, "value", v)
+								configRoot[substring] = v
+							case map[interface{}]interface{}:
+								cc.Logger.V(10).Info("type map[interface{}]interface{}", "key", kv.Key)
+								if len(v) == 0 {
+									delete(configRoot, substring)
+								} else {
 									configRoot[substring] = v
-								case map[interface{}]interface{}:
-									cc.Logger.V(10).Info("type map[interface{}]interface{}", "key", kv.Key)
-									if len(v) == 0 {
-										delete(configRoot, substring)
-									} else {
-										configRoot[substring] = v
-									}
-								case []interface{}:
-									cc.Logger.V(10).Info("type []interface{}", "key", kv.Key)
-									if len(v) == 0 {
-										delete(configRoot, substring)
-									} else {
-										configRoot[substring] = v
-									}
-								default:
-									cc.Logger.V(10).Info("type default", "key", kv.Key)
+								}
+							case []interface{}:
+								cc.Logger.V(10).Info("type []interface{}", "key", kv.Key)
+								if len(v) == 0 {
+									delete(configRoot, substring)
+								} else {
 									configRoot[substring] = v
 								}
+							default:
+								cc.Logger.V(10).Info("type default", "key", kv.Key)
+								configRoot[substring] = v
 							}
 						}
 					}
@@ -282,45 +430,43 @@ func (cc *ChartConfig) Values() (string, error) {
 			}
 		}
 	}
-	out, err = yaml.Marshal(root)
-	if err != nil {
-		return str, nil
-	}
-
-	str += fmt.Sprintf("%s\n", string(out))
-	return str, nil
+	return errs
 }
 
-func (c *ChartConfig) formatKey(key, prefix string, keyType KeyType, strategy XPathStrategy) string {
+func (c *ChartConfig) formatKey(key, prefix string, keyType KeyType, strategy XPathStrategy) (string, error) {
 	switch keyType {
 	case KeyTypeFile:
-		return fmt.Sprintf(".Values.%s.%s", prefix, key)
+		return valuesReference(append([]string{prefix}, keyPath(key)...)), nil
 	case KeyTypeShared, KeyTypeNotFound:
-		formattedKey := fmt.Sprintf(".Values.%s", key)
+		// Conditions may refer to shared values that are not defined, which are false.
 		if keyType == KeyTypeNotFound && strategy != XPathStrategyControlIf && strategy != XPathStrategyControlIfYAML {
-			panic(fmt.Sprintf("%s not found", key))
+			return "", fmt.Errorf("key '%s.%s' not found in sharedValues", sharedValuesPrefix, key)
 		}
-		return formattedKey
+		return fmt.Sprintf(".Values.%s", key), nil
 	default:
-		return key
+		return key, nil
 	}
 }
 
-func (c *ChartConfig) GetFormattedCondition(xc *XPathConfig, prefix string) (string, bool) {
-	formatCondition := func(condition string) (string, bool) {
+func (c *ChartConfig) GetFormattedCondition(xc *XPathConfig, prefix string) (string, bool, error) {
+	formatCondition := func(condition string) (string, bool, error) {
 		not := strings.HasPrefix(condition, "!")
 		conditionKey := strings.TrimPrefix(condition, "!")
 		key, keyType := c.determineKeyType(conditionKey)
 		if key == "" {
-			return "", not
+			return "", not, nil
 		}
-		return c.formatKey(key, prefix, keyType, xc.Strategy), not
+		key, err := c.formatKey(key, prefix, keyType, xc.Strategy)
+		return key, not, err
 	}
 
-	formatMultipleConditions := func(conditions []Condition) (string, bool) {
+	formatMultipleConditions := func(conditions []Condition) (string, bool, error) {
 		keys := make([]string, len(conditions))
 		for i, condition := range conditions {
-			key, not := formatCondition(condition.Key)
+			key, not, err := formatCondition(condition.Key)
+			if err != nil {
+				return "", false, err
+			}
 			if key != "" {
 				if not {
 					key = fmt.Sprintf("(not %s)", key)
@@ -329,7 +475,7 @@ func (c *ChartConfig) GetFormattedCondition(xc *XPathConfig, prefix string) (str
 			}
 		}
 
-		return fmt.Sprintf("%s %s", *xc.ConditionOperator, strings.Join(keys, " ")), false
+		return fmt.Sprintf("%s %s", *xc.ConditionOperator, strings.Join(keys, " ")), false, nil
 	}
 
 	if xc.Condition != "" {
@@ -340,72 +486,31 @@ func (c *ChartConfig) GetFormattedCondition(xc *XPathConfig, prefix string) (str
 		}
 		return formatMultipleConditions(xc.Conditions)
 	}
-	return "", false
+	return "", false, nil
 }
 
-func (c *ChartConfig) GetFormattedKeyWithDefaultValue(xc *XPathConfig, prefix string) (string, KeyType) {
+func (c *ChartConfig) GetFormattedKeyWithDefaultValue(xc *XPathConfig, prefix string) (string, KeyType, error) {
 	key, keyType := c.determineKeyType(xc.Key)
 	if key == "" {
-		return key, keyType
+		return key, keyType, nil
+	}
+	key, err := c.formatKey(key, prefix, keyType, xc.Strategy)
+	if err != nil {
+		return "", keyType, err
 	}
-	key = c.formatKey(key, prefix, keyType, xc.Strategy)
 	if xc.DefaultValue != nil {
 		key = fmt.Sprintf("%s | default %s", key, xc.DefaultValue)
 	}
-	return key, keyType
+	return key, keyType, nil
 }
 
+// Validate reports every problem found in the configuration.
 func (c *ChartConfig) Validate() error {
-	// Validates
-	// - file-if can only be present at root level file configs
-	// - globalConfig cannot contain a root level entry
-	// - inline-regex must have regex property, and the regex must compile and contain exactly one capture group
-	// - control-if and control-if-yaml with multiple conditions:
-	//   - must have conditionOperator property
-	//   - conditionOperator must be 'and' or 'or'
-	// - other strategies cannot have condition or conditions property
-	if _, ok := c.GlobalConfig[XPathRoot]; ok {
-		return fmt.Errorf("cannot have root level config in GlobalConfig")
-	}
-
-	for manifest, config := range c.FileConfig {
-		for xpath, xpathConfigs := range config {
-			for i, xpathConfig := range xpathConfigs {
-				strategy := xpathConfig.Strategy
-				if (xpath == XPathRoot) != (strategy == XPathStrategyFileIf) {
-					return fmt.Errorf("'%s' cannot use strategy '%s' at '%s'", manifest, strategy, xpath)
-				}
-				if strategy == XPathStrategyInlineRegex {
-					if xpathConfig.Regex == "" {
-						return fmt.Errorf("'%s' strategy '%s' must have 'regex' property", manifest, strategy)
-					}
-					rx := regexp2.MustCompile(xpathConfig.Regex, regexp2.Compiled)
-					if len(rx.GetGroupNumbers()) != 2 {
-						// groups[0] is the entire match. groups[1] is the bit within ()
-						return fmt.Errorf("'%s' strategy '%s': regular expression '%s' must have exactly one replacement group", manifest, strategy, xpathConfig.Regex)
-					}
-					xpathConfigs[i].RegexCompiled = rx
-				} else if strategy == XPathStrategyControlIf || strategy == XPathStrategyControlIfYAML {
-					if len(xpathConfig.Conditions) > 1 {
-						if xpathConfig.ConditionOperator == nil {
-							return fmt.Errorf("'%s' strategy '%s' must have 'conditionOperator' property", manifest, strategy)
-						}
-					}
-					if xpathConfig.ConditionOperator != nil {
-						if *xpathConfig.ConditionOperator != "and" && *xpathConfig.ConditionOperator != "or" {
-							return fmt.Errorf("'%s' strategy '%s' conditionOperator must be 'and' or 'or'", manifest, strategy)
-						}
-					}
-				} else {
-					if xpathConfig.Condition != "" || len(xpathConfig.Conditions) > 0 {
-						return fmt.Errorf("'%s' strategy '%s' cannot have 'condition' or 'conditions' property", manifest, strategy)
-					}
-				}
-			}
-		}
+	var errs []error
+	for _, problem := range c.problems() {
+		errs = append(errs, errors.New(problem.Message))
 	}
-
-	return nil
+	return errors.Join(errs...)
 }
 
 func (c *ChartConfig) keyExist(key string) (string, bool) {
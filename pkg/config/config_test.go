@@ -307,3 +307,47 @@ func TestRegexStrategyWithOnlyOneCaptureGroupSucceeds(t *testing.T) {
 
 	require.NoError(t, config.Validate())
 }
+
+func TestValidateNamingTemplate(t *testing.T) {
+	logger := zap.New()
+	config := NewChartConfig(logger, "chart")
+
+	config.NamingTemplate = "{{ .kind | lower }}-{{ .name }}"
+	require.NoError(t, config.Validate())
+
+	config.NamingTemplate = "{{ .kind | unknown }}"
+	require.Error(t, config.Validate())
+}
+
+func TestXPathInDocument(t *testing.T) {
+	require.Equal(t, XPath("spec.replicas"), XPath("spec.replicas").InDocument(0))
+	require.Equal(t, XPath("$2.spec.replicas"), XPath("spec.replicas").InDocument(2))
+	require.Equal(t, XPath("$1"), XPath(XPathRoot).InDocument(1))
+
+	index, local := XPath("$2.spec.replicas").Document()
+	require.Equal(t, 2, index)
+	require.Equal(t, XPath("spec.replicas"), local)
+	index, local = XPath("$1").Document()
+	require.Equal(t, 1, index)
+	require.True(t, local.IsRoot())
+	index, local = XPath("spec.replicas").Document()
+	require.Equal(t, 0, index)
+	require.Equal(t, XPath("spec.replicas"), local)
+}
+
+func TestValuesReportsConflicts(t *testing.T) {
+	config := NewChartConfig(zap.New(), "chart")
+	for _, filename := range []string{"daemonset.yaml", "deployment.yaml"} {
+		config.FileConfig[filename] = Config{
+			"spec.image": []XPathConfig{
+				{Strategy: XPathStrategyInline, Key: "image", Value: "nginx"},
+			},
+			"spec.tag": []XPathConfig{
+				{Strategy: XPathStrategyInline, Key: "image.tag"},
+			},
+		}
+	}
+	_, err := config.Values()
+	require.EqualError(t, err, "fileConfig 'daemonset.yaml': XPath 'spec.tag' rule 0: key 'image.tag' conflicts with the value of 'image'\n"+
+		"fileConfig 'deployment.yaml': XPath 'spec.tag' rule 0: key 'image.tag' conflicts with the value of 'image'")
+}
@@ -4,6 +4,12 @@ const (
 	XPathRoot           = ""
 	XPathSliceIndexNone = -1
 	XPathSeparator      = "."
+	// XPaths of the second and following documents of a multi-document file are prefixed with e.g. $1.
+	XPathDocumentPrefix = "$"
+	// XPathWildcard selects every element of a list, as in containers[*].image.
+	XPathWildcard = "*"
+	// XPathSelectorOperator selects the element of a list by a field, as in containers[name=manager].image.
+	XPathSelectorOperator = "="
 
 	sharedValuesPrefix  = "sharedValues"
 	builtInValuesPrefix = ".Chart."
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/go-logr/logr"
+)
+
+// Load reads the configuration file at path. Files in an older format are upgraded in memory, see
+// Migrate. The files it includes are not merged, see WithIncludes.
+func Load(logger logr.Logger, path string) (*ChartConfig, error) {
+	c, changes, err := Migrate(logger, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		logger.Info("Config file uses an older format, run 'kustohelmize config migrate' to upgrade it", "path", path)
+	}
+	return c, nil
+}
+
+// WithIncludes returns the configuration with the files it includes merged in. Included files are
+// resolved relative to the including file, may include other files themselves, and are merged in the
+// order they are listed, followed by the including file, so that later files take precedence:
+//   - chartname and namingTemplate are taken from the last file that sets them
+//   - sharedValues are merged key by key, maps recursively
+//   - globalConfig and each fileConfig entry are merged XPath by XPath
+//   - selectorConfig entries are appended, so the selectors of later files win
+//
+// The resources indexed by IndexManifests are kept.
+func (c *ChartConfig) WithIncludes() (*ChartConfig, error) {
+	merged := &ChartConfig{
+		Logger:       c.Logger,
+		APIVersion:   c.APIVersion,
+		SharedValues: GenericMap{},
+		GlobalConfig: Config{},
+		FileConfig:   map[string]Config{},
+		path:         c.path,
+		identities:   c.identities,
+		documents:    c.documents,
+	}
+	if err := merged.include(c, nil); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// Merge other and the files it includes into c. stack holds the including files, to detect cycles.
+func (c *ChartConfig) include(other *ChartConfig, stack []string) error {
+	if other.path != "" {
+		stack = append(stack, filepath.Clean(other.path))
+	}
+	for _, include := range other.Include {
+		path := include
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(other.path), path)
+		}
+		if slices.Contains(stack, filepath.Clean(path)) {
+			return fmt.Errorf("%s: include '%s': cyclic include", other.path, include)
+		}
+		included, err := Load(c.Logger, path)
+		if err != nil {
+			return fmt.Errorf("%s: include '%s': %w", other.path, include, err)
+		}
+		if err := c.include(included, stack); err != nil {
+			return err
+		}
+	}
+	c.merge(other)
+	c.sources = append(c.sources, other)
+	return nil
+}
+
+func (c *ChartConfig) merge(other *ChartConfig) {
+	if other.Chartname != "" {
+		c.Chartname = other.Chartname
+	}
+	if other.NamingTemplate != "" {
+		c.NamingTemplate = other.NamingTemplate
+	}
+	for key, value := range other.SharedValues {
+		c.SharedValues[key] = mergeValue(c.SharedValues[key], value)
+	}
+	for xpath, xpathConfigs := range other.GlobalConfig {
+		c.GlobalConfig[xpath] = xpathConfigs
+	}
+	c.SelectorConfig = append(c.SelectorConfig, other.SelectorConfig...)
+	for key, fileConfig := range other.FileConfig {
+		if c.FileConfig[key] == nil {
+			c.FileConfig[key] = Config{}
+		}
+		for xpath, xpathConfigs := range fileConfig {
+			c.FileConfig[key][xpath] = xpathConfigs
+		}
+	}
+}
+
+// Merge the value src over dst. Maps are merged recursively, anything else is replaced.
+func mergeValue(dst, src interface{}) interface{} {
+	s, ok := asMap(src)
+	if !ok {
+		return src
+	}
+	d, ok := asMap(dst)
+	if !ok {
+		return src
+	}
+	merged := map[interface{}]interface{}{}
+	for key, value := range d {
+		merged[key] = value
+	}
+	for key, value := range s {
+		merged[key] = mergeValue(d[key], value)
+	}
+	return merged
+}
+
+// Nested maps are unmarshalled as map[interface{}]interface{}, but built in code as GenericMap.
+func asMap(value interface{}) (map[interface{}]interface{}, bool) {
+	switch m := value.(type) {
+	case map[interface{}]interface{}:
+		return m, true
+	case GenericMap:
+		converted := make(map[interface{}]interface{}, len(m))
+		for key, value := range m {
+			converted[key] = value
+		}
+		return converted, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestWithIncludes(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "common"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common", "labels.config"), []byte(`chartname: common
+globalConfig:
+  metadata.labels:
+  - strategy: newline
+    key: common.labels
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common", "workloads.config"), []byte(`chartname: common
+include:
+- labels.config
+namingTemplate: "{{ .kind | lower }}-{{ .name }}"
+sharedValues:
+  resources:
+    limits:
+      cpu: 500m
+      memory: 128Mi
+  nodeSelector: {}
+globalConfig:
+  metadata.namespace:
+  - strategy: inline
+    key: sharedValues.namespace
+selectorConfig:
+- selector:
+    kinds:
+    - Deployment
+  config:
+    spec.replicas:
+    - strategy: inline
+      key: replicas
+fileConfig:
+  apps/v1/Deployment//nginx:
+    spec.replicas:
+    - strategy: inline
+      key: replicas
+      value: 1
+    spec.template.spec.containers[0].image:
+    - strategy: inline
+      key: image
+`), 0644))
+	path := filepath.Join(dir, "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`chartname: mychart
+include:
+- common/workloads.config
+sharedValues:
+  namespace: web
+  resources:
+    limits:
+      cpu: "1"
+globalConfig:
+  metadata.labels:
+  - strategy: newline
+    key: mychart.labels
+selectorConfig:
+- selector:
+    kinds:
+    - Deployment
+  config:
+    spec.replicas:
+    - strategy: inline
+      key: replicaCount
+fileConfig:
+  apps/v1/Deployment//nginx:
+    spec.replicas:
+    - strategy: inline
+      key: replicas
+      value: 3
+`), 0644))
+
+	config, err := Load(zap.New(), path)
+	require.NoError(t, err)
+	merged, err := config.WithIncludes()
+	require.NoError(t, err)
+	require.NoError(t, merged.Validate())
+
+	require.Equal(t, "mychart", merged.Chartname)
+	require.Equal(t, "{{ .kind | lower }}-{{ .name }}", merged.NamingTemplate)
+	require.Equal(t, GenericMap{
+		"namespace": "web",
+		"resources": map[interface{}]interface{}{
+			"limits": map[interface{}]interface{}{"cpu": "1", "memory": "128Mi"},
+		},
+		"nodeSelector": map[interface{}]interface{}{},
+	}, merged.SharedValues)
+	require.Equal(t, "mychart.labels", merged.GlobalConfig["metadata.labels"][0].Key)
+	require.Equal(t, "sharedValues.namespace", merged.GlobalConfig["metadata.namespace"][0].Key)
+	require.Len(t, merged.SelectorConfig, 2)
+	require.Equal(t, "replicaCount", merged.SelectorConfig[1].Config["spec.replicas"][0].Key)
+	nginx := merged.FileConfig["apps/v1/Deployment//nginx"]
+	require.Equal(t, 3, nginx["spec.replicas"][0].Value)
+	require.Equal(t, "image", nginx["spec.template.spec.containers[0].image"][0].Key)
+
+	// The including configuration itself is left untouched.
+	require.Equal(t, []string{"common/workloads.config"}, config.Include)
+	require.Len(t, config.GlobalConfig, 1)
+}
+
+func TestWithIncludesErrors(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.config"), []byte("chartname: a\ninclude:\n- b.config\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.config"), []byte("chartname: b\ninclude:\n- a.config\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "c.config"), []byte("chartname: c\ninclude:\n- missing.config\n"), 0644))
+
+	config, err := Load(zap.New(), filepath.Join(dir, "a.config"))
+	require.NoError(t, err)
+	_, err = config.WithIncludes()
+	require.ErrorContains(t, err, "include 'a.config': cyclic include")
+
+	config, err = Load(zap.New(), filepath.Join(dir, "c.config"))
+	require.NoError(t, err)
+	_, err = config.WithIncludes()
+	require.ErrorContains(t, err, "include 'missing.config'")
+
+	diagnostics, err := ValidateFile(filepath.Join(dir, "c.config"))
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 1)
+	require.Equal(t, 3, diagnostics[0].Line)
+	require.Contains(t, diagnostics[0].Message, "include 'missing.config': open ")
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/iancoleman/strcase"
+	"gopkg.in/yaml.v2"
+)
+
+// The keys of the pod spec of the workload kinds.
+var podSpecKeys = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// Infer adds configurations for the commonly parameterized fields of the indexed resources to their
+// fileConfig entries: the replicas of workloads, the images, ports, env and resources of their
+// containers, and the type and ports of services. Fields that are missing or empty are skipped.
+// The values of the manifests are written to values.yaml, and the configurations are marked as
+// inferred so that they can be reviewed. XPaths that are already configured for a resource, by the
+// configuration or the files it includes, are left as they are. Only the configuration itself is
+// changed. It reports whether it changed.
+func (c *ChartConfig) Infer() (bool, error) {
+	merged, err := c.WithIncludes()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, identity := range c.Identities() {
+		ref := c.identities[identity]
+		object := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(ref.resource.Raw, &object); err != nil {
+			return changed, fmt.Errorf("%s: %w", ref.resource.Location(), err)
+		}
+		configured := merged.configuredXPaths(ref)
+		for xpath, xpathConfigs := range infer(ref.resource.Kind, object) {
+			if configured.has(xpath) {
+				continue
+			}
+			for i := range xpathConfigs {
+				xpathConfigs[i].Inferred = true
+			}
+			if c.FileConfig == nil {
+				c.FileConfig = map[string]Config{}
+			}
+			if c.FileConfig[identity] == nil {
+				c.FileConfig[identity] = Config{}
+			}
+			c.FileConfig[identity][xpath] = xpathConfigs
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func infer(kind string, object map[interface{}]interface{}) Config {
+	config := Config{}
+	if kind == "Service" {
+		if serviceType, ok := lookup(object, "spec", "type"); ok {
+			config["spec.type"] = XPathConfigs{{Strategy: XPathStrategyInline, Key: "type", Value: serviceType}}
+		}
+		if ports, ok := lookup(object, "spec", "ports"); ok && !isEmpty(ports) {
+			config["spec.ports"] = XPathConfigs{{Strategy: XPathStrategyNewlineYAML, Key: "ports", Value: ports}}
+		}
+		return config
+	}
+
+	keys, ok := podSpecKeys[kind]
+	if !ok {
+		return config
+	}
+	if replicas, ok := lookup(object, "spec", "replicas"); ok {
+		config["spec.replicas"] = XPathConfigs{{Strategy: XPathStrategyInline, Key: "replicas", Value: replicas}}
+	}
+	podSpec := XPath(XPathRoot)
+	for _, key := range keys {
+		podSpec = podSpec.NewChild(key, XPathSliceIndexNone)
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _ := lookup(object, append(keys, field)...)
+		for name, container := range namedContainers(list) {
+			xpath := containerXPath(podSpec, field, name)
+			key := strcase.ToLowerCamel(name)
+			if image, ok := container["image"].(string); ok {
+				config[xpath.NewChild("image", XPathSliceIndexNone)] = imageConfigs(key, image)
+			}
+			for _, child := range []string{"ports", "env", "resources"} {
+				if value, ok := container[child]; ok && !isEmpty(value) {
+					config[xpath.NewChild(child, XPathSliceIndexNone)] = XPathConfigs{{Strategy: XPathStrategyNewlineYAML, Key: key + "." + child, Value: value}}
+				}
+			}
+		}
+	}
+	return config
+}
+
+// Empty maps and lists are not inferred, since they are omitted from the value of an XPathConfig.
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return value == nil
+	}
+}
+
+// Index the containers of a list by name. Containers without a name are skipped.
+func namedContainers(list interface{}) map[string]map[interface{}]interface{} {
+	containers := map[string]map[interface{}]interface{}{}
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		container, ok := item.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		if name, ok := container["name"].(string); ok {
+			containers[name] = container
+		}
+	}
+	return containers
+}
+
+// Containers are selected by name, which is kept when patches add or reorder containers.
+func containerXPath(podSpec XPath, field, name string) XPath {
+	return podSpec.NewChild(field, XPathSliceIndexNone) + XPath(segment{kind: segmentSelector, key: "name", value: name}.String())
+}
+
+// Parameterize the repository of an image and, if it is tagged, its tag under key.image.
+func imageConfigs(key, image string) XPathConfigs {
+	repository, tag := splitImage(image)
+	xpathConfigs := XPathConfigs{{Strategy: XPathStrategyInline, Key: key + ".image.repository", Value: repository}}
+	if tag != "" {
+		xpathConfigs = append(xpathConfigs, XPathConfig{Strategy: XPathStrategyInline, Key: key + ".image.tag", Value: tag})
+	}
+	return xpathConfigs
+}
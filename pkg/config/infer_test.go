@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestInfer(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web-deployment.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: web:v1
+      containers:
+      - name: web-server
+        image: nginx:1.25
+        ports:
+        - containerPort: 80
+        env:
+        - name: MODE
+          value: production
+        resources: {}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cleanup-cronjob.yaml"), []byte(`apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: busybox
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web-svc.yaml"), []byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: NodePort
+  ports:
+  - port: 80
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web-cm.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  mode: production
+`), 0644))
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig["apps/v1/Deployment//web"] = Config{
+		"spec.replicas": []XPathConfig{{Strategy: XPathStrategyInline, Key: "replicaCount"}},
+	}
+	require.NoError(t, config.IndexManifests(dir))
+
+	changed, err := config.Infer()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoError(t, config.Validate())
+
+	deployment := config.FileConfig["apps/v1/Deployment//web"]
+	// Configured XPaths are kept.
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyInline, Key: "replicaCount"}}, deployment["spec.replicas"])
+	require.Equal(t, XPathConfigs{
+		{Strategy: XPathStrategyInline, Key: "webServer.image.repository", Value: "nginx", Inferred: true},
+		{Strategy: XPathStrategyInline, Key: "webServer.image.tag", Value: "1.25", Inferred: true},
+	}, deployment["spec.template.spec.containers[name=web-server].image"])
+	require.Equal(t, XPathConfigs{
+		{Strategy: XPathStrategyNewlineYAML, Key: "webServer.env", Value: []interface{}{map[interface{}]interface{}{"name": "MODE", "value": "production"}}, Inferred: true},
+	}, deployment["spec.template.spec.containers[name=web-server].env"])
+	require.Contains(t, deployment, XPath("spec.template.spec.containers[name=web-server].ports"))
+	require.Contains(t, deployment, XPath("spec.template.spec.initContainers[name=migrate].image"))
+	// Empty values are skipped.
+	require.NotContains(t, deployment, XPath("spec.template.spec.containers[name=web-server].resources"))
+
+	require.Equal(t, XPathConfigs{
+		{Strategy: XPathStrategyInline, Key: "cleanup.image.repository", Value: "busybox", Inferred: true},
+	}, config.FileConfig["batch/v1/CronJob//cleanup"]["spec.jobTemplate.spec.template.spec.containers[name=cleanup].image"])
+
+	service := config.FileConfig["v1/Service//web"]
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyInline, Key: "type", Value: "NodePort", Inferred: true}}, service["spec.type"])
+	require.Equal(t, XPathStrategyNewlineYAML, service["spec.ports"][0].Strategy)
+
+	require.Empty(t, config.FileConfig["v1/ConfigMap//web"])
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "  webServer:\n    env:\n    - name: MODE\n      value: production\n")
+
+	// Inferring again changes nothing.
+	changed, err = config.Infer()
+	require.NoError(t, err)
+	require.False(t, changed)
+}
+
+func TestInferWithIncludes(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "mychart-generated")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app-deployment.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "common.config"), []byte(`apiVersion: kustohelmize.io/v1
+fileConfig:
+  apps/v1/Deployment//app:
+    spec.replicas:
+    - strategy: inline
+      key: replicaCount
+    spec.template.spec.containers[*].image:
+    - strategy: inline
+      key: '*.image'
+`), 0644))
+	path := filepath.Join(root, "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: kustohelmize.io/v1
+chartname: mychart
+include:
+- common.config
+`), 0644))
+
+	config, err := Load(zap.New(), path)
+	require.NoError(t, err)
+	require.NoError(t, config.IndexManifests(dir))
+	changed, err := config.Infer()
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Empty(t, config.FileConfig["apps/v1/Deployment//app"])
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yeahdongcn/kustohelmize/pkg/util"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// lintTarget is a resource that a configuration applies to. The name is empty for fileConfig,
+// which is named after its resource already.
+type lintTarget struct {
+	name string
+	node *yamlv3.Node
+}
+
+// Lint reports the rules of the configuration that have no effect on the indexed manifests: XPaths
+// that match no node, strategies applied to a node they cannot template, and sharedValues that are
+// never referenced. References lists the keys of values.yaml referred to outside the configuration,
+// such as by the helpers of the chart. The fileConfig entries of resources that are not indexed are
+// left to purge. The globalConfig rules seeded by NewChartConfig are not reported, and neither are its
+// sharedValues while they are empty.
+// Problems are located in the file the configuration was loaded from or, for a configuration returned
+// by WithIncludes, in the last file that defines the rule or value. The error is only set if one of
+// those files cannot be read.
+func (c *ChartConfig) Lint(references []string) ([]Diagnostic, error) {
+	sources := c.sources
+	if len(sources) == 0 {
+		sources = []*ChartConfig{c}
+	}
+	roots := make([]*yamlv3.Node, len(sources))
+	for i, source := range sources {
+		roots[i] = &yamlv3.Node{}
+		if source.path == "" {
+			continue
+		}
+		bs, err := os.ReadFile(source.path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yamlv3.Unmarshal(bs, roots[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	problems := []problem{}
+	targets := []lintTarget{}
+	for _, identity := range c.Identities() {
+		if node := resourceNode(c.identities[identity].resource); node != nil {
+			targets = append(targets, lintTarget{name: identity, node: node})
+		}
+	}
+	seeded := defaultGlobalConfig(c.Chartname)
+	for _, xpath := range sortedXPaths(c.GlobalConfig) {
+		// The rules seeded by NewChartConfig apply to whichever resources have the field.
+		if reflect.DeepEqual(seeded[xpath], c.GlobalConfig[xpath]) {
+			continue
+		}
+		problems = append(problems, lintXPath([]string{sectionGlobalConfig}, sectionGlobalConfig, xpath, xpath, c.GlobalConfig[xpath], targets, false)...)
+	}
+
+	for i, selectorConfig := range c.SelectorConfig {
+		path := []string{sectionSelectorConfig, strconv.Itoa(i)}
+		// Entries are named by their index in the file that defines them.
+		_, local := c.origin(path)
+		name := fmt.Sprintf("%s[%s]", sectionSelectorConfig, local[1])
+		selected := []lintTarget{}
+		for _, target := range targets {
+			if selectorConfig.Selector.Matches(c.identities[target.name].resource) {
+				selected = append(selected, target)
+			}
+		}
+		if len(selected) == 0 {
+			problems = append(problems, problem{Path: append(path, "selector"), Message: fmt.Sprintf("'%s' selects no resource", name)})
+			continue
+		}
+		for _, xpath := range sortedXPaths(selectorConfig.Config) {
+			problems = append(problems, lintXPath(append(path, "config"), name, xpath, xpath, selectorConfig.Config[xpath], selected, true)...)
+		}
+	}
+
+	for _, key := range sortedKeys(c.FileConfig) {
+		_, indexed := c.identities[key]
+		documents := c.isPathKey(key)
+		if documents {
+			_, indexed = c.documents[filepath.Clean(key)]
+		}
+		if !indexed {
+			continue
+		}
+		config := c.FileConfig[key]
+		for _, xpath := range sortedXPaths(config) {
+			// XPaths of path keys may be prefixed with their document.
+			index, local := 0, xpath
+			if documents {
+				index, local = xpath.Document()
+			}
+			targets := []lintTarget{}
+			if node := c.documentNode(key, index); node != nil {
+				targets = append(targets, lintTarget{node: node})
+			}
+			problems = append(problems, lintXPath([]string{sectionFileConfig, key}, key, xpath, local, config[xpath], targets, true)...)
+		}
+	}
+
+	problems = append(problems, c.unusedSharedValues(references)...)
+
+	located := make([][]problem, len(sources))
+	for _, p := range problems {
+		i, path := c.origin(p.Path)
+		p.Path = path
+		located[i] = append(located[i], p)
+	}
+	diagnostics := []Diagnostic{}
+	for i, source := range sources {
+		diagnostics = append(diagnostics, diagnose(source.path, roots[i], located[i])...)
+	}
+	return diagnostics, nil
+}
+
+// Find the configuration merged by WithIncludes that the path of a problem comes from, which is the
+// last one defining it, and the path within that configuration. selectorConfig entries are appended
+// as they are merged, and are indexed within their own configuration.
+func (c *ChartConfig) origin(path []string) (int, []string) {
+	last := len(c.sources) - 1
+	if last < 0 || len(path) < 2 {
+		return max(last, 0), path
+	}
+	defines := func(source *ChartConfig) bool {
+		switch path[0] {
+		case sectionGlobalConfig:
+			_, ok := source.GlobalConfig[XPath(path[1])]
+			return ok
+		case sectionFileConfig:
+			if len(path) < 3 {
+				_, ok := source.FileConfig[path[1]]
+				return ok
+			}
+			_, ok := source.FileConfig[path[1]][XPath(path[2])]
+			return ok
+		case sharedValuesPrefix:
+			return hasSharedValue(source.SharedValues, path[1:])
+		}
+		return false
+	}
+	if path[0] == sectionSelectorConfig {
+		index, err := strconv.Atoi(path[1])
+		if err != nil {
+			return last, path
+		}
+		for i, source := range c.sources {
+			if index < len(source.SelectorConfig) {
+				return i, append([]string{path[0], strconv.Itoa(index)}, path[2:]...)
+			}
+			index -= len(source.SelectorConfig)
+		}
+		return last, path
+	}
+	for i := last; i >= 0; i-- {
+		if defines(c.sources[i]) {
+			return i, path
+		}
+	}
+	return last, path
+}
+
+func hasSharedValue(values map[string]interface{}, keys []string) bool {
+	value, ok := values[keys[0]]
+	if !ok {
+		return false
+	}
+	if len(keys) == 1 {
+		return true
+	}
+	children, ok := sharedMap(value)
+	return ok && hasSharedValue(children, keys[1:])
+}
+
+// Check the rules of the XPath at path against the resources they apply to. The XPath is local to
+// its document, which is the XPath itself but for the path keys of fileConfig. Rules of fileConfig
+// and selectorConfig, unlike those of globalConfig, also apply to lists and their elements.
+func lintXPath(path []string, name string, xpath, local XPath, xpathConfigs XPathConfigs, targets []lintTarget, file bool) []problem {
+	segments, err := local.segments()
+	if err != nil {
+		// Reported by Validate.
+		return nil
+	}
+	problems := []problem{}
+	at := func(keys ...string) []string {
+		p := append([]string{}, path...)
+		return append(append(p, string(xpath)), keys...)
+	}
+	element := len(segments) > 0 && segments[len(segments)-1].kind != segmentKey
+
+	// A rule that cannot be applied is reported for the first resource only.
+	reported := make([]bool, len(xpathConfigs))
+	matched := false
+	for _, target := range targets {
+		in := ""
+		if target.name != "" {
+			in = fmt.Sprintf(" in '%s'", target.name)
+		}
+		// XPaths in the legacy form apply as ExpandConfig resolves them.
+		segments := segments
+		if canonical, ok := resolveLegacy(local, target.node); ok {
+			segments, _ = canonical.segments()
+		}
+		err := match(target.node, segments, XPathRoot, nil, path[0] == sectionFileConfig, func(_ XPath, _ []string, node *yamlv3.Node) {
+			matched = true
+			for i, xpathConfig := range xpathConfigs {
+				if reported[i] {
+					continue
+				}
+				if message, ok := strategyMismatch(xpathConfig.Strategy, element, file, node); ok {
+					reported[i] = true
+					problems = append(problems, problem{
+						Path:    at(strconv.Itoa(i), "strategy"),
+						Message: fmt.Sprintf("'%s' strategy '%s' at '%s' %s%s", name, xpathConfig.Strategy, xpath, message, in),
+					})
+				}
+			}
+		})
+		if err != nil {
+			problems = append(problems, problem{Path: at(), Message: fmt.Sprintf("'%s' %s%s", name, err, in)})
+			matched = true
+		}
+	}
+	if !matched {
+		message := fmt.Sprintf("'%s' XPath '%s' matches no node", name, xpath)
+		switch path[0] {
+		case sectionGlobalConfig:
+			message += " in any resource"
+		case sectionSelectorConfig:
+			message += " in any selected resource"
+		}
+		problems = append(problems, problem{Path: at(), Message: message})
+	}
+	return problems
+}
+
+// Describe why a strategy has no effect on the node it is applied to. Only control-if applies to
+// list elements, and inline-regex and append-with apply to lists, all of which only in file rules.
+func strategyMismatch(strategy XPathStrategy, element bool, file bool, node *yamlv3.Node) (string, bool) {
+	if element {
+		if !file {
+			return "cannot apply to a list element", true
+		}
+		if strategy != XPathStrategyControlIf {
+			return fmt.Sprintf("cannot apply to a list element, only '%s' can", XPathStrategyControlIf), true
+		}
+		return "", false
+	}
+	node = util.ResolveNode(node)
+	switch strategy {
+	case XPathStrategyControlRange:
+		if node.Kind != yamlv3.SequenceNode {
+			return fmt.Sprintf("expects a list, found %s", nodeKind(node)), true
+		}
+	case XPathStrategyInlineRegex, XPathStrategyAppendWith:
+		if !file {
+			return fmt.Sprintf("cannot apply in %s", sectionGlobalConfig), true
+		}
+		// Lists of maps are walked element by element, other lists are templated as a whole.
+		maps := node.Kind == yamlv3.SequenceNode && len(node.Content) > 0 && util.ResolveNode(node.Content[0]).Kind == yamlv3.MappingNode
+		if strategy == XPathStrategyInlineRegex && (node.Kind != yamlv3.SequenceNode || maps) {
+			return fmt.Sprintf("expects a list of strings, found %s", nodeKind(node)), true
+		}
+		if strategy == XPathStrategyAppendWith && !maps {
+			return fmt.Sprintf("expects a list of maps, found %s", nodeKind(node)), true
+		}
+	}
+	return "", false
+}
+
+func nodeKind(node *yamlv3.Node) string {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		return "a map"
+	case yamlv3.SequenceNode:
+		if len(node.Content) == 0 {
+			return "an empty list"
+		}
+		if util.ResolveNode(node.Content[0]).Kind == yamlv3.MappingNode {
+			return "a list of maps"
+		}
+		return "a list of scalars"
+	default:
+		return "a scalar"
+	}
+}
+
+// The sharedValues that neither a rule, by key or by condition, nor the values keys of helpers refer
+// to. A map is reported as a whole if none of its keys are referred to, and key by key otherwise.
+// The values seeded by NewChartConfig are left out until they are given a value.
+func (c *ChartConfig) unusedSharedValues(helpers []string) []problem {
+	references := [][]string{}
+	for _, key := range helpers {
+		references = append(references, keyPath(key))
+	}
+	refer := func(key string) {
+		key = strings.TrimPrefix(key, "!")
+		if strings.HasPrefix(key, sharedValuesPrefix+XPathSeparator) {
+			references = append(references, keyPath(key)[1:])
+		}
+	}
+	c.eachConfig(func(_ []string, _ string, config Config) {
+		for _, xpathConfigs := range config {
+			for _, xpathConfig := range xpathConfigs {
+				refer(xpathConfig.Key)
+				refer(xpathConfig.Condition)
+				for _, condition := range xpathConfig.Conditions {
+					refer(condition.Key)
+				}
+			}
+		}
+	})
+	values := map[string]interface{}{}
+	seeded := defaultSharedValues()
+	for key, value := range c.SharedValues {
+		if _, ok := seeded[key]; ok && isEmptyValue(value) {
+			continue
+		}
+		values[key] = value
+	}
+	return unusedValues(values, nil, references)
+}
+
+func unusedValues(values map[string]interface{}, path []string, references [][]string) []problem {
+	problems := []problem{}
+	for _, key := range sortedKeys(values) {
+		keys := append(append([]string{}, path...), key)
+		used, partly := false, false
+		for _, reference := range references {
+			// The reference is either the value, one of its parents, or one of its keys.
+			n := min(len(keys), len(reference))
+			if !slices.Equal(reference[:n], keys[:n]) {
+				continue
+			}
+			if len(reference) <= len(keys) {
+				used = true
+			} else {
+				partly = true
+			}
+		}
+		if used {
+			continue
+		}
+		if partly {
+			if children, ok := sharedMap(values[key]); ok {
+				problems = append(problems, unusedValues(children, keys, references)...)
+				continue
+			}
+		}
+		problems = append(problems, problem{
+			Path:    append([]string{sharedValuesPrefix}, keys...),
+			Message: fmt.Sprintf("'%s' is never referenced", strings.Join(append([]string{sharedValuesPrefix}, keys...), XPathSeparator)),
+		})
+	}
+	return problems
+}
+
+// Nested sharedValues are decoded as maps keyed by interface{}.
+func sharedMap(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case GenericMap:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		return stringKeys(m), true
+	}
+	return nil, false
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestLint(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+	path := filepath.Join(filepath.Dir(dir), "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: kustohelmize.io/v1
+chartname: mychart
+sharedValues:
+  replicas: 1
+  image:
+    repository: nginx
+    tag: latest
+    pullPolicy: Always
+  unused: {}
+globalConfig:
+  metadata.name:
+  - strategy: inline
+    key: mychart.fullname
+  metadata.annotations:
+  - strategy: newline-yaml
+    key: annotations
+selectorConfig:
+- selector:
+    kinds: [ConfigMap]
+  config:
+    data:
+    - strategy: newline-yaml
+      key: data
+- selector:
+    kinds: [Service]
+  config:
+    spec.ports:
+    - strategy: newline-yaml
+      key: ports
+fileConfig:
+  apps/v1/Deployment//nginx:
+    metadata.labels:
+    - strategy: control-if
+      key: sharedValues.image.repository
+    spec.replicas:
+    - strategy: control-range
+      key: sharedValues.replicas
+  apps/v1/Deployment//gone:
+    spec.replicas:
+    - strategy: inline
+      key: replicas
+  %s:
+    $1.spec.type:
+    - strategy: inline
+      key: metricsType
+    $2.spec.type:
+    - strategy: inline
+      key: otherType
+`, filepath.Join(dir, "nginx-svc.yaml"))), 0644))
+
+	config, err := Load(zap.New(), path)
+	require.NoError(t, err)
+	require.NoError(t, config.IndexManifests(dir))
+	diagnostics, err := config.Lint([]string{"image.tag"})
+	require.NoError(t, err)
+
+	messages := []string{}
+	for _, diagnostic := range diagnostics {
+		messages = append(messages, diagnostic.String())
+	}
+	require.Equal(t, []string{
+		path + ":8:17: 'sharedValues.image.pullPolicy' is never referenced",
+		path + ":9:3: 'sharedValues.unused' is never referenced",
+		path + ":14:3: 'globalConfig' XPath 'metadata.annotations' matches no node in any resource",
+		path + ":18:3: 'selectorConfig[0]' selects no resource",
+		path + ":27:5: 'selectorConfig[1]' XPath 'spec.ports' matches no node in any selected resource",
+		path + ":36:17: 'apps/v1/Deployment//nginx' strategy 'control-range' at 'spec.replicas' expects a list, found a scalar",
+		path + ":46:5: '" + filepath.Join(dir, "nginx-svc.yaml") + "' XPath '$2.spec.type' matches no node",
+	}, messages)
+}
+
+func TestLintIncludes(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+	path := filepath.Join(filepath.Dir(dir), "mychart.config")
+	common := filepath.Join(filepath.Dir(dir), "common.config")
+	require.NoError(t, os.WriteFile(common, []byte(`apiVersion: kustohelmize.io/v1
+sharedValues:
+  annotations: {}
+fileConfig:
+  apps/v1/Deployment//nginx:
+    spec.replicas:
+    - strategy: inline
+      key: sharedValues.replicas
+selectorConfig:
+- selector:
+    kinds: [ConfigMap]
+  config:
+    data:
+    - strategy: newline-yaml
+      key: data
+`), 0644))
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: kustohelmize.io/v1
+chartname: mychart
+include:
+- common.config
+sharedValues:
+  replicas: 1
+selectorConfig:
+- selector:
+    kinds: [Secret]
+  config:
+    data:
+    - strategy: newline-yaml
+      key: data
+`), 0644))
+
+	config, err := Load(zap.New(), path)
+	require.NoError(t, err)
+	require.NoError(t, config.IndexManifests(dir))
+	config, err = config.WithIncludes()
+	require.NoError(t, err)
+	diagnostics, err := config.Lint(nil)
+	require.NoError(t, err)
+
+	messages := []string{}
+	for _, diagnostic := range diagnostics {
+		messages = append(messages, diagnostic.String())
+	}
+	// sharedValues.replicas is referenced by the included rule.
+	require.Equal(t, []string{
+		common + ":3:3: 'sharedValues.annotations' is never referenced",
+		common + ":10:3: 'selectorConfig[0]' selects no resource",
+		path + ":8:3: 'selectorConfig[0]' selects no resource",
+	}, messages)
+}
+
+func TestLintNewChartConfig(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "settings-cm.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  debug: "false"
+`), 0644))
+	config := NewChartConfig(zap.New(), "mychart")
+	require.NoError(t, config.IndexManifests(dir))
+	// None of the resources has labels, and the seeded sharedValues are empty.
+	diagnostics, err := config.Lint(nil)
+	require.NoError(t, err)
+	require.Empty(t, diagnostics)
+
+	// Seeded values are reported once they are given a value.
+	config.SharedValues["resources"] = GenericMap{"limits": GenericMap{"cpu": "500m"}}
+	diagnostics, err = config.Lint(nil)
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 1)
+	require.Equal(t, "'sharedValues.resources' is never referenced", diagnostics[0].Message)
+}
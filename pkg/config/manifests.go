@@ -0,0 +1,331 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yeahdongcn/kustohelmize/pkg/manifest"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// manifestRef locates a resource within the intermediate files.
+type manifestRef struct {
+	path     string
+	document int
+	resource *manifest.Resource
+}
+
+// IndexManifests records the intermediate file and document every resource in dir was written to,
+// so that fileConfig can be keyed by resource identity (apiVersion/kind/namespace/name) as well as
+// by intermediate file path. A missing directory is treated as empty.
+func (c *ChartConfig) IndexManifests(dir string) error {
+	c.identities = map[string]manifestRef{}
+	c.documents = map[string][]string{}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		resources, err := manifest.Split(f, path)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		identities := make([]string, len(resources))
+		for i, resource := range resources {
+			identity := resource.Identity()
+			if owner, ok := c.identities[identity]; ok {
+				return fmt.Errorf("%s: duplicate resource '%s', already defined in %s", resource.Location(), identity, owner.path)
+			}
+			c.identities[identity] = manifestRef{path: path, document: i, resource: resource}
+			identities[i] = identity
+		}
+		c.documents[path] = identities
+	}
+	return nil
+}
+
+// A fileConfig key is the identity of a resource if an indexed resource has it, or if it reads as
+// apiVersion/kind/namespace/name, since names such as app.yaml may carry a manifest extension.
+// Otherwise, it is an intermediate file path if it carries a manifest extension.
+func (c *ChartConfig) isPathKey(key string) bool {
+	if _, ok := c.identities[key]; ok || isIdentity(key) {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(key)) {
+	case manifest.FileExt, ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// Kinds are upper camel case, which tells identities from paths such as mychart-generated/x/y/app.yaml.
+var kindRegex = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// Report whether key is made of apiVersion/kind/namespace/name, the apiVersion of which may have a group.
+func isIdentity(key string) bool {
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 && len(parts) != 5 {
+		return false
+	}
+	return parts[0] != "" && parts[len(parts)-1] != "" && kindRegex.MatchString(parts[len(parts)-3])
+}
+
+// ManifestPath returns the intermediate file of a fileConfig key, which is either
+// the path itself or the identity of a resource in an indexed file.
+func (c *ChartConfig) ManifestPath(key string) (string, bool) {
+	if ref, ok := c.identities[key]; ok {
+		return ref.path, true
+	}
+	if c.isPathKey(key) {
+		return filepath.Clean(key), true
+	}
+	return "", false
+}
+
+// Manifests returns the intermediate files to be templated: the indexed files and the files
+// referred to by fileConfig path keys.
+func (c *ChartConfig) Manifests() []string {
+	seen := map[string]bool{}
+	paths := []string{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for path := range c.documents {
+		add(path)
+	}
+	for key := range c.FileConfig {
+		if c.isPathKey(key) {
+			add(filepath.Clean(key))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Identities returns the identities of the indexed resources, sorted.
+func (c *ChartConfig) Identities() []string {
+	identities := make([]string, 0, len(c.identities))
+	for identity := range c.identities {
+		identities = append(identities, identity)
+	}
+	sort.Strings(identities)
+	return identities
+}
+
+// DocumentConfig returns the configuration of a document of an intermediate file, with plain
+// (unprefixed) XPaths and its patterns expanded against the document node. Entries of the resource
+// identity take precedence over those of the file path, which take precedence over those of the
+// selectors matching the resource. Patterns that cannot be expanded are reported, and the rest of
+// the configuration is returned along with the error.
+func (c *ChartConfig) DocumentConfig(path string, document int, node *yamlv3.Node) (Config, error) {
+	config := Config{}
+	var errs []error
+	if ref, ok := c.documentRef(path, document); ok {
+		selected, err := ExpandSharedConfig(c.selectedConfig(ref.resource), node)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", sectionSelectorConfig, ref.resource.Identity(), err))
+		}
+		config = selected
+	}
+	fileConfig, err := ExpandConfig(c.fileDocumentConfig(path, document), node)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", sectionFileConfig, err))
+	}
+	for xpath, xpathConfigs := range fileConfig {
+		config[xpath] = xpathConfigs
+	}
+	if err := errors.Join(errs...); err != nil {
+		location := (&manifest.Resource{Source: path, Index: document}).Location()
+		return config, fmt.Errorf("%s: %w", location, err)
+	}
+	return config, nil
+}
+
+// The fileConfig entries of a document, keyed by its path or by its identity.
+func (c *ChartConfig) fileDocumentConfig(path string, document int) Config {
+	config := Config{}
+	for key, fileConfig := range c.FileConfig {
+		if !c.isPathKey(key) || filepath.Clean(key) != path {
+			continue
+		}
+		for xpath, xpathConfigs := range fileConfig {
+			if index, local := xpath.Document(); index == document {
+				config[local] = xpathConfigs
+			}
+		}
+	}
+	if identities := c.documents[path]; document < len(identities) {
+		for xpath, xpathConfigs := range c.FileConfig[identities[document]] {
+			config[xpath] = xpathConfigs
+		}
+	}
+	return config
+}
+
+// configuredXPaths are the XPaths configured for the document of a resource, in canonical form, as
+// written and as they resolve against the resource, so that patterns and XPaths in the legacy form
+// count as configured.
+type configuredXPaths struct {
+	xpaths map[XPath]bool
+	node   *yamlv3.Node
+}
+
+func (c *ChartConfig) configuredXPaths(ref manifestRef) *configuredXPaths {
+	configured := &configuredXPaths{xpaths: map[XPath]bool{}, node: resourceNode(ref.resource)}
+	config := c.fileDocumentConfig(ref.path, ref.document)
+	for xpath := range config {
+		configured.add(xpath)
+	}
+	expanded, _ := ExpandConfig(config, configured.node)
+	for xpath := range expanded {
+		configured.xpaths[xpath] = true
+	}
+	return configured
+}
+
+func (c *configuredXPaths) add(xpath XPath) {
+	c.xpaths[xpath.canonical()] = true
+}
+
+// Report whether xpath, or a node it matches, is configured.
+func (c *configuredXPaths) has(xpath XPath) bool {
+	if c.xpaths[xpath.canonical()] {
+		return true
+	}
+	expanded, _ := ExpandConfig(Config{xpath: nil}, c.node)
+	for xpath := range expanded {
+		if c.xpaths[xpath] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChartConfig) documentRef(path string, document int) (manifestRef, bool) {
+	identities := c.documents[path]
+	if document >= len(identities) {
+		return manifestRef{}, false
+	}
+	return c.identities[identities[document]], true
+}
+
+// Parse the indexed resource at document of the fileConfig key, or return nil if it is not indexed.
+func (c *ChartConfig) documentNode(key string, document int) *yamlv3.Node {
+	ref, ok := c.identities[key]
+	if !ok || document != 0 {
+		if !c.isPathKey(key) {
+			return nil
+		}
+		if ref, ok = c.documentRef(filepath.Clean(key), document); !ok {
+			return nil
+		}
+	}
+	return resourceNode(ref.resource)
+}
+
+func resourceNode(resource *manifest.Resource) *yamlv3.Node {
+	node := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(resource.Raw, node); err != nil {
+		return nil
+	}
+	return node
+}
+
+// Expand the patterns of a fileConfig entry against its indexed resources. Patterns of
+// documents that are not indexed cannot be expanded and are left out.
+func (c *ChartConfig) expandFileConfig(key string, config Config) (Config, error) {
+	if !hasPatterns(config) {
+		return config, nil
+	}
+	documents := map[int]Config{}
+	for xpath, xpathConfigs := range config {
+		index, local := xpath.Document()
+		if documents[index] == nil {
+			documents[index] = Config{}
+		}
+		documents[index][local] = xpathConfigs
+	}
+	expanded := Config{}
+	var errs []error
+	for _, index := range sortedKeys(documents) {
+		documentConfig := documents[index]
+		if hasPatterns(documentConfig) {
+			var err error
+			documentConfig, err = ExpandConfig(documentConfig, c.documentNode(key, index))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: '%s': %w", sectionFileConfig, key, err))
+			}
+		}
+		for xpath, xpathConfigs := range documentConfig {
+			expanded[xpath.InDocument(index)] = xpathConfigs
+		}
+	}
+	return expanded, errors.Join(errs...)
+}
+
+// MigrateFileConfigKeys rewrites the fileConfig entries keyed by intermediate file path to be keyed
+// by resource identity, splitting multi-document files into one entry per resource. Entries that
+// cannot be migrated, because their file or document is not indexed or because the identity entry
+// already configures the same XPath, are kept under the path and returned as messages.
+func (c *ChartConfig) MigrateFileConfigKeys() []string {
+	skipped := []string{}
+	for _, path := range sortedKeys(c.FileConfig) {
+		if !c.isPathKey(path) {
+			continue
+		}
+		identities, ok := c.documents[filepath.Clean(path)]
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("'%s' is not an intermediate file", path))
+			continue
+		}
+
+		// Every resource of the file keeps an entry, even if none of its XPaths are configured.
+		for _, identity := range identities {
+			if c.FileConfig[identity] == nil {
+				c.FileConfig[identity] = Config{}
+			}
+		}
+		config := c.FileConfig[path]
+		for _, xpath := range sortedXPaths(config) {
+			index, local := xpath.Document()
+			if index >= len(identities) {
+				skipped = append(skipped, fmt.Sprintf("'%s' has no document %d for '%s'", path, index, xpath))
+				continue
+			}
+			identity := identities[index]
+			if _, ok := c.FileConfig[identity][local]; ok {
+				skipped = append(skipped, fmt.Sprintf("'%s' already configures '%s'", identity, local))
+				continue
+			}
+			c.FileConfig[identity][local] = config[xpath]
+			delete(config, xpath)
+		}
+		if len(config) == 0 {
+			delete(c.FileConfig, path)
+		}
+	}
+	return skipped
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func writeIntermediateFiles(t *testing.T) string {
+	dir := filepath.Join(t.TempDir(), "mychart-generated")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nginx-deployment.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  labels:
+    app: nginx
+spec:
+  replicas: 1
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nginx-svc.yaml"), []byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+  namespace: web
+spec:
+  type: ClusterIP
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx-metrics
+  namespace: web
+spec:
+  type: ClusterIP
+`), 0644))
+	return dir
+}
+
+func documentConfig(t *testing.T, config *ChartConfig, path string, document int) Config {
+	documentConfig, err := config.DocumentConfig(path, document, nil)
+	require.NoError(t, err)
+	return documentConfig
+}
+
+func TestFileConfigByIdentity(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+	deployment := filepath.Join(dir, "nginx-deployment.yaml")
+	service := filepath.Join(dir, "nginx-svc.yaml")
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig["apps/v1/Deployment//nginx"] = Config{
+		"spec.replicas": []XPathConfig{{Strategy: XPathStrategyInline, Key: "replicas", Value: 3}},
+	}
+	config.FileConfig[service] = Config{
+		"spec.type":    []XPathConfig{{Strategy: XPathStrategyInline, Key: "type", Value: "ClusterIP"}},
+		"$1.spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "metricsType", Value: "ClusterIP"}},
+	}
+	config.FileConfig["v1/Service/web/nginx-metrics"] = Config{
+		"spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "metrics.type", Value: "NodePort"}},
+	}
+	config.FileConfig["v1/ConfigMap//gone"] = Config{}
+	require.NoError(t, config.IndexManifests(dir))
+
+	require.Equal(t, []string{deployment, service}, config.Manifests())
+	require.Equal(t, []string{"apps/v1/Deployment//nginx", "v1/Service/web/nginx", "v1/Service/web/nginx-metrics"}, config.Identities())
+
+	path, ok := config.ManifestPath("apps/v1/Deployment//nginx")
+	require.True(t, ok)
+	require.Equal(t, deployment, path)
+	_, ok = config.ManifestPath("v1/ConfigMap//gone")
+	require.False(t, ok)
+
+	require.Equal(t, "replicas", documentConfig(t, config, deployment, 0)["spec.replicas"][0].Key)
+	require.Equal(t, "type", documentConfig(t, config, service, 0)["spec.type"][0].Key)
+	// The identity takes precedence over the path.
+	require.Equal(t, "metrics.type", documentConfig(t, config, service, 1)["spec.type"][0].Key)
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "nginxDeployment:\n  replicas: 3\n")
+	require.Contains(t, values, "nginxSvc:\n")
+	require.Contains(t, values, "  metrics:\n    type: NodePort\n")
+}
+
+func TestMigrateFileConfigKeys(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+	deployment := filepath.Join(dir, "nginx-deployment.yaml")
+	service := filepath.Join(dir, "nginx-svc.yaml")
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig[deployment] = Config{}
+	config.FileConfig[service] = Config{
+		"spec.type":    []XPathConfig{{Strategy: XPathStrategyInline, Key: "type"}},
+		"$1":           []XPathConfig{{Strategy: XPathStrategyFileIf, Key: "metrics.enabled"}},
+		"$1.spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "metrics.type"}},
+		"$2.spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "other.type"}},
+	}
+	config.FileConfig["v1/Service/web/nginx"] = Config{
+		"metadata.labels": []XPathConfig{{Strategy: XPathStrategyNewline, Key: "labels"}},
+	}
+	config.FileConfig["elsewhere/old-cm.yaml"] = Config{}
+	require.NoError(t, config.IndexManifests(dir))
+
+	skipped := config.MigrateFileConfigKeys()
+	require.Len(t, skipped, 2)
+	require.Contains(t, skipped[0], "has no document 2 for '$2.spec.type'")
+	require.Contains(t, skipped[1], "'elsewhere/old-cm.yaml' is not an intermediate file")
+
+	require.NotContains(t, config.FileConfig, deployment)
+	require.Equal(t, Config{}, config.FileConfig["apps/v1/Deployment//nginx"])
+	require.Equal(t, Config{
+		"spec.type":       []XPathConfig{{Strategy: XPathStrategyInline, Key: "type"}},
+		"metadata.labels": []XPathConfig{{Strategy: XPathStrategyNewline, Key: "labels"}},
+	}, config.FileConfig["v1/Service/web/nginx"])
+	require.Equal(t, Config{
+		"":          []XPathConfig{{Strategy: XPathStrategyFileIf, Key: "metrics.enabled"}},
+		"spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "metrics.type"}},
+	}, config.FileConfig["v1/Service/web/nginx-metrics"])
+	require.Equal(t, Config{
+		"$2.spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "other.type"}},
+	}, config.FileConfig[service])
+	require.NoError(t, config.Validate())
+}
+
+func TestIdentityKeysWithManifestExtension(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+	path := filepath.Join(dir, "app.yaml-cm.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app.yaml
+  namespace: default
+data:
+  key: value
+`), 0644))
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig["v1/ConfigMap/default/app.yaml"] = Config{
+		"data.key": []XPathConfig{{Strategy: XPathStrategyInline, Key: "key"}},
+	}
+	require.NoError(t, config.IndexManifests(dir))
+
+	manifestPath, ok := config.ManifestPath("v1/ConfigMap/default/app.yaml")
+	require.True(t, ok)
+	require.Equal(t, path, manifestPath)
+	require.Contains(t, documentConfig(t, config, path, 0), XPath("data.key"))
+	require.Empty(t, config.MigrateFileConfigKeys())
+
+	// Identities are told from paths without the index too.
+	require.False(t, config.isPathKey("rbac.authorization.k8s.io/v1/Role/default/gone.yaml"))
+	require.True(t, config.isPathKey("mychart-generated/gone.yaml"))
+	require.True(t, config.isPathKey(filepath.Join(dir, "gone.yaml")))
+}
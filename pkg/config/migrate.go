@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-logr/logr"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	// APIVersionV1 is the first versioned format of the configuration file. Files without an
+	// apiVersion predate it.
+	APIVersionV1 = "kustohelmize.io/v1"
+	// APIVersion is the format of the configuration files written by this version of kustohelmize.
+	APIVersion = APIVersionV1
+)
+
+// migration upgrades a decoded configuration file from one apiVersion to the next.
+type migration struct {
+	from string
+	to   string
+	// migrate rewrites the file in place and describes the changes it made.
+	migrate func(document map[interface{}]interface{}) ([]string, error)
+}
+
+// The migrations in order, each one starting from the apiVersion the previous one ends with.
+var migrations = []migration{
+	{from: "", to: APIVersionV1, migrate: migrateToV1},
+}
+
+// Migrate reads the configuration file at path and upgrades it to APIVersion, applying the
+// migrations from its apiVersion on. It returns the upgraded configuration and describes the
+// changes made, which are empty if the file is up to date. The file itself is not modified.
+//
+// The file is parsed strictly: fields that the configuration does not have, such as misspelled
+// properties, and unknown strategies are errors, located in the file.
+func Migrate(logger logr.Logger, path string) (*ChartConfig, []string, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	document := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(bs, &document); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	changes, err := migrate(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	migrated := bs
+	if len(changes) > 0 {
+		if migrated, err = yaml.Marshal(document); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	c := &ChartConfig{Logger: logger}
+	if err := yaml.Unmarshal(migrated, c); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(bs, root); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if diagnostics := strictDiagnostics(path, root, migrated, c); len(diagnostics) > 0 {
+		return nil, nil, strictError(diagnostics)
+	}
+	c.path = path
+	return c, changes, nil
+}
+
+// Apply the migrations from the apiVersion of document on.
+func migrate(document map[interface{}]interface{}) ([]string, error) {
+	version := ""
+	if value, ok := document["apiVersion"]; ok {
+		if version, ok = value.(string); !ok {
+			return nil, fmt.Errorf("apiVersion must be a string, got '%v'", value)
+		}
+	}
+
+	changes := []string{}
+	for _, m := range migrations {
+		if m.from != version {
+			continue
+		}
+		migrated, err := m.migrate(document)
+		if err != nil {
+			return nil, fmt.Errorf("migrating to apiVersion '%s': %w", m.to, err)
+		}
+		document["apiVersion"] = m.to
+		changes = append(changes, migrated...)
+		changes = append(changes, fmt.Sprintf("set apiVersion to '%s'", m.to))
+		version = m.to
+	}
+	if version != APIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion '%s', must be '%s' or older", version, APIVersion)
+	}
+	return changes, nil
+}
+
+// Unversioned files may have the logger, written as an empty map, and the deprecated condition and
+// conditionValue properties, which are converted to conditions.
+func migrateToV1(document map[interface{}]interface{}) ([]string, error) {
+	changes := []string{}
+	if _, ok := document["logger"]; ok {
+		delete(document, "logger")
+		changes = append(changes, "removed logger")
+	}
+
+	migrateConfig := func(name string, config interface{}) error {
+		m, _ := config.(map[interface{}]interface{})
+		xpaths := stringKeys(m)
+		for _, xpath := range sortedKeys(xpaths) {
+			xpathConfigs, _ := xpaths[xpath].([]interface{})
+			for i, item := range xpathConfigs {
+				xpathConfig, ok := item.(map[interface{}]interface{})
+				if !ok {
+					continue
+				}
+				at := fmt.Sprintf("%s '%s'[%d]", name, xpath, i)
+				migrated, err := migrateCondition(xpathConfig)
+				if err != nil {
+					return fmt.Errorf("%s: %w", at, err)
+				}
+				if migrated {
+					changes = append(changes, fmt.Sprintf("converted condition of %s to conditions", at))
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := migrateConfig(sectionGlobalConfig, document[sectionGlobalConfig]); err != nil {
+		return nil, err
+	}
+	selectorConfigs, _ := document[sectionSelectorConfig].([]interface{})
+	for i, item := range selectorConfigs {
+		selectorConfig, _ := item.(map[interface{}]interface{})
+		if err := migrateConfig(fmt.Sprintf("%s[%d]", sectionSelectorConfig, i), selectorConfig["config"]); err != nil {
+			return nil, err
+		}
+	}
+	m, _ := document[sectionFileConfig].(map[interface{}]interface{})
+	fileConfigs := stringKeys(m)
+	for _, key := range sortedKeys(fileConfigs) {
+		if err := migrateConfig(fmt.Sprintf("%s '%s'", sectionFileConfig, key), fileConfigs[key]); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// Convert the condition and conditionValue of an XPathConfig to conditions, reporting whether
+// the XPathConfig had them.
+func migrateCondition(xpathConfig map[interface{}]interface{}) (bool, error) {
+	condition, hasCondition := xpathConfig["condition"]
+	value, hasValue := xpathConfig["conditionValue"]
+	if !hasCondition && !hasValue {
+		return false, nil
+	}
+	delete(xpathConfig, "condition")
+	delete(xpathConfig, "conditionValue")
+	if key, ok := condition.(string); hasCondition && (!ok || key == "") {
+		return false, fmt.Errorf("condition must be a non-empty string, got '%v'", condition)
+	}
+	if !hasCondition {
+		// A conditionValue without a condition has no effect.
+		return true, nil
+	}
+	if conditions, ok := xpathConfig["conditions"].([]interface{}); ok && len(conditions) > 0 {
+		return false, fmt.Errorf("cannot have both 'condition' and 'conditions'")
+	}
+
+	converted := map[interface{}]interface{}{"key": condition}
+	if hasValue {
+		enabled, ok := value.(bool)
+		if !ok {
+			return false, fmt.Errorf("conditionValue must be a boolean, got '%v'", value)
+		}
+		if enabled {
+			converted["value"] = true
+		}
+	}
+	xpathConfig["conditions"] = []interface{}{converted}
+	return true, nil
+}
+
+// Index a decoded map by the string form of its keys.
+func stringKeys(m map[interface{}]interface{}) map[string]interface{} {
+	keys := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		keys[fmt.Sprint(key)] = value
+	}
+	return keys
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`logger: {}
+chartname: mychart
+globalConfig:
+  metadata.labels:
+  - strategy: newline
+    key: mychart.labels
+selectorConfig:
+- selector:
+    kinds: [Deployment]
+  config:
+    spec.replicas:
+    - strategy: control-if
+      condition: "!sharedValues.autoscaling.enabled"
+      key: replicas
+fileConfig:
+  apps/v1/Deployment//nginx:
+    spec.template.spec.containers[0].ports:
+    - strategy: control-if-yaml
+      condition: expose.enable
+      conditionValue: true
+      key: ports
+`), 0644))
+
+	config, changes, err := Migrate(zap.New(), path)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"removed logger",
+		"converted condition of selectorConfig[0] 'spec.replicas'[0] to conditions",
+		"converted condition of fileConfig 'apps/v1/Deployment//nginx' 'spec.template.spec.containers[0].ports'[0] to conditions",
+		"set apiVersion to 'kustohelmize.io/v1'",
+	}, changes)
+	require.Equal(t, APIVersion, config.APIVersion)
+	require.NoError(t, config.Validate())
+
+	replicas := config.SelectorConfig[0].Config["spec.replicas"][0]
+	require.Empty(t, replicas.Condition)
+	require.Equal(t, []Condition{{Key: "!sharedValues.autoscaling.enabled"}}, replicas.Conditions)
+	ports := config.FileConfig["apps/v1/Deployment//nginx"]["spec.template.spec.containers[0].ports"][0]
+	require.Equal(t, []Condition{{Key: "expose.enable", Value: true}}, ports.Conditions)
+	require.False(t, ports.ConditionValue)
+
+	// The logger is no longer written, and the migrated file is up to date.
+	output, err := yaml.Marshal(config)
+	require.NoError(t, err)
+	require.NotContains(t, string(output), "logger")
+	require.NoError(t, os.WriteFile(path, output, 0644))
+	_, changes, err = Migrate(zap.New(), path)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func TestMigrateErrors(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		err    string
+	}{
+		"newer apiVersion": {
+			config: "apiVersion: kustohelmize.io/v9\nchartname: mychart\n",
+			err:    "unsupported apiVersion 'kustohelmize.io/v9', must be 'kustohelmize.io/v1' or older",
+		},
+		"condition and conditions": {
+			config: `chartname: mychart
+globalConfig:
+  spec.replicas:
+  - strategy: control-if
+    key: replicas
+    condition: a
+    conditions:
+    - key: b
+`,
+			err: "migrating to apiVersion 'kustohelmize.io/v1': globalConfig 'spec.replicas'[0]: cannot have both 'condition' and 'conditions'",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mychart.config")
+			require.NoError(t, os.WriteFile(path, []byte(test.config), 0644))
+			_, _, err := Migrate(zap.New(), path)
+			require.ErrorContains(t, err, test.err)
+			_, err = Load(zap.New(), path)
+			require.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+	"github.com/yeahdongcn/kustohelmize/pkg/util"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PresetWorkloadStandard = "workload-standard"
+	PresetRBACToggle       = "rbac-toggle"
+
+	rbacCreateKey = sharedValuesPrefix + ".rbac.create"
+)
+
+// preset generates the XPath configurations of a kind of resources.
+type preset struct {
+	description string
+	kinds       []string
+	// configure returns the configuration of a resource, given its content, and reports whether
+	// it changed the sharedValues of c. What is defined already is looked up in merged, the
+	// configuration with its included files, see WithIncludes.
+	configure func(c, merged *ChartConfig, object map[interface{}]interface{}) (Config, bool)
+}
+
+var presets = map[string]preset{
+	PresetWorkloadStandard: {
+		description: "Parameterize the replicas, images, image pull policies, resources and security contexts of the containers, and the scheduling, security context and image pull secrets of the pods of workloads, with sharedValues where the workloads agree.",
+		kinds:       workloadKinds,
+		configure:   workloadStandard,
+	},
+	PresetRBACToggle: {
+		description: "Create RBAC resources only if sharedValues.rbac.create is true.",
+		kinds:       []string{"Role", "ClusterRole", "RoleBinding", "ClusterRoleBinding"},
+		configure:   rbacToggle,
+	},
+}
+
+// PresetNames returns the names of the built-in presets, sorted.
+func PresetNames() []string {
+	return sortedKeys(presets)
+}
+
+// PresetDescription describes what a built-in preset configures.
+func PresetDescription(name string) string {
+	return presets[name].description
+}
+
+// ApplyPresets adds the configuration of the named presets to the fileConfig entries of the indexed
+// resources they apply to. XPaths that are already configured for a resource, by the configuration or
+// the files it includes, are left as they are, so applying presets again does not change anything.
+// Only the configuration itself is changed. It reports whether it changed, including its sharedValues.
+func (c *ChartConfig) ApplyPresets(names []string) (bool, error) {
+	for _, name := range names {
+		if _, ok := presets[name]; !ok {
+			message := fmt.Sprintf("unknown preset '%s'", name)
+			if match, ok := util.ClosestMatch(name, PresetNames()); ok {
+				message += fmt.Sprintf(", did you mean '%s'?", match)
+			}
+			return false, fmt.Errorf("%s, must be one of %s", message, strings.Join(PresetNames(), ", "))
+		}
+	}
+
+	if len(names) == 0 {
+		return false, nil
+	}
+	merged, err := c.WithIncludes()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, identity := range c.Identities() {
+		ref := c.identities[identity]
+		object := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(ref.resource.Raw, &object); err != nil {
+			return changed, fmt.Errorf("%s: %w", ref.resource.Location(), err)
+		}
+		configured := merged.configuredXPaths(ref)
+		for _, name := range names {
+			p := presets[name]
+			if !slices.Contains(p.kinds, ref.resource.Kind) {
+				continue
+			}
+			config, seeded := p.configure(c, merged, object)
+			changed = changed || seeded
+			for xpath, xpathConfigs := range config {
+				if configured.has(xpath) {
+					continue
+				}
+				if c.FileConfig == nil {
+					c.FileConfig = map[string]Config{}
+				}
+				if c.FileConfig[identity] == nil {
+					c.FileConfig[identity] = Config{}
+				}
+				c.FileConfig[identity][xpath] = xpathConfigs
+				configured.add(xpath)
+				changed = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+var workloadKinds = []string{"Deployment", "StatefulSet", "DaemonSet"}
+
+// The fields of the pod, and of a single container, that may refer to sharedValues, which create
+// seeds for most of them.
+var workloadSharedValues = []struct{ field, key string }{
+	{"nodeSelector", "nodeSelector"},
+	{"tolerations", "tolerations"},
+	{"affinity", "affinity"},
+	{"securityContext", "podSecurityContext"},
+	{"imagePullSecrets", "imagePullSecrets"},
+}
+
+var containerSharedValues = []struct{ field, key string }{
+	{"resources", "resources"},
+	{"securityContext", "securityContext"},
+}
+
+// Fields are only parameterized if the manifest has them, as the templates are generated by walking
+// the manifest and a field that is absent cannot be templated. A field refers to sharedValues only if
+// every workload that has it has the same value, and sharedValues holds no other value for it. The
+// value of the manifest is then moved to sharedValues. Otherwise the field gets a key of its own.
+func workloadStandard(c, merged *ChartConfig, object map[interface{}]interface{}) (Config, bool) {
+	config := Config{}
+	seeded := false
+	// newline-yaml writes the value of the manifest to values.yaml.
+	yamlValue := func(xpath XPath, key string, value interface{}) {
+		config[xpath] = XPathConfigs{{Strategy: XPathStrategyNewlineYAML, Key: key, Value: value}}
+	}
+	// control-with leaves the field out while the shared value is empty.
+	sharedValue := func(xpath XPath, sharedKey, key string, value interface{}, field func(map[interface{}]interface{}) (interface{}, bool)) {
+		if !merged.sharedByWorkloads(sharedKey, value, field) {
+			yamlValue(xpath, key, value)
+			return
+		}
+		config[xpath] = XPathConfigs{{Strategy: XPathStrategyControlWith, Key: sharedValuesPrefix + XPathSeparator + sharedKey}}
+		if c.seedSharedValue(merged, sharedKey, value) {
+			seeded = true
+		}
+	}
+
+	if replicas, ok := lookup(object, "spec", "replicas"); ok {
+		config["spec.replicas"] = XPathConfigs{{Strategy: XPathStrategyInline, Key: "replicas", Value: replicas}}
+	}
+
+	podSpec := XPath("spec.template.spec")
+	for _, shared := range workloadSharedValues {
+		field := func(object map[interface{}]interface{}) (interface{}, bool) {
+			return lookup(object, "spec", "template", "spec", shared.field)
+		}
+		if value, ok := field(object); ok {
+			sharedValue(podSpec.NewChild(shared.field, XPathSliceIndexNone), shared.key, shared.key, value, field)
+		}
+	}
+
+	containers, _ := lookup(object, "spec", "template", "spec", "containers")
+	named := namedContainers(containers)
+	for name, container := range named {
+		xpath := containerXPath(podSpec, "containers", name)
+		key := strcase.ToLowerCamel(name)
+
+		if image, ok := container["image"].(string); ok {
+			config[xpath.NewChild("image", XPathSliceIndexNone)] = imageConfigs(key, image)
+		}
+		if pullPolicy, ok := container["imagePullPolicy"].(string); ok {
+			config[xpath.NewChild("imagePullPolicy", XPathSliceIndexNone)] = XPathConfigs{{Strategy: XPathStrategyInline, Key: key + ".image.pullPolicy", Value: pullPolicy}}
+		}
+		// The resources and security context of a single container are those of the workload.
+		for _, shared := range containerSharedValues {
+			value, ok := container[shared.field]
+			if !ok {
+				continue
+			}
+			if len(named) == 1 {
+				field := func(object map[interface{}]interface{}) (interface{}, bool) {
+					return singleContainerField(object, shared.field)
+				}
+				sharedValue(xpath.NewChild(shared.field, XPathSliceIndexNone), shared.key, key+"."+shared.field, value, field)
+			} else {
+				yamlValue(xpath.NewChild(shared.field, XPathSliceIndexNone), key+"."+shared.field, value)
+			}
+		}
+	}
+	return config, seeded
+}
+
+// Report whether the workloads can share the value of a field at a key of sharedValues: every indexed
+// workload with the field has value, and the key holds no other value.
+func (c *ChartConfig) sharedByWorkloads(key string, value interface{}, field func(map[interface{}]interface{}) (interface{}, bool)) bool {
+	if current := c.SharedValues[key]; !isEmptyValue(current) && !reflect.DeepEqual(current, value) {
+		return false
+	}
+	for _, identity := range c.Identities() {
+		resource := c.identities[identity].resource
+		if !slices.Contains(workloadKinds, resource.Kind) {
+			continue
+		}
+		object := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(resource.Raw, &object); err != nil {
+			continue
+		}
+		if other, ok := field(object); ok && !reflect.DeepEqual(other, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Look up a field of the container of a workload that has a single one.
+func singleContainerField(object map[interface{}]interface{}, field string) (interface{}, bool) {
+	containers, _ := lookup(object, "spec", "template", "spec", "containers")
+	named := namedContainers(containers)
+	if len(named) != 1 {
+		return nil, false
+	}
+	for _, container := range named {
+		value, ok := container[field]
+		return value, ok
+	}
+	return nil, false
+}
+
+func rbacToggle(c, merged *ChartConfig, _ map[interface{}]interface{}) (Config, bool) {
+	seeded := false
+	if _, ok := merged.keyExist(rbacCreateKey); !ok {
+		for _, config := range []*ChartConfig{c, merged} {
+			if config.SharedValues == nil {
+				config.SharedValues = GenericMap{}
+			}
+			rbac, ok := asMap(config.SharedValues["rbac"])
+			if !ok {
+				rbac = map[interface{}]interface{}{}
+			}
+			rbac["create"] = true
+			config.SharedValues["rbac"] = rbac
+		}
+		seeded = true
+	}
+	return Config{
+		XPathRoot: XPathConfigs{{Strategy: XPathStrategyFileIf, Key: rbacCreateKey}},
+	}, seeded
+}
+
+// Set a top-level key of sharedValues to value, unless it holds a value already in merged, and report
+// whether it was set. The empty values create seeds do not count, but are not replaced by empty ones.
+// The value is set in merged as well, for the next resources to find it.
+func (c *ChartConfig) seedSharedValue(merged *ChartConfig, key string, value interface{}) bool {
+	if current, ok := merged.SharedValues[key]; ok && (!isEmptyValue(current) || isEmptyValue(value)) {
+		return false
+	}
+	for _, config := range []*ChartConfig{c, merged} {
+		if config.SharedValues == nil {
+			config.SharedValues = GenericMap{}
+		}
+		config.SharedValues[key] = value
+	}
+	return true
+}
+
+// Split an image into its repository and tag. An image without a tag, or pinned by digest,
+// is kept as the repository.
+func splitImage(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	colon := strings.LastIndex(image, ":")
+	if colon > strings.LastIndex(image, "/") {
+		return image[:colon], image[colon+1:]
+	}
+	return image, ""
+}
+
+// Look up the value at the keys of an object.
+func lookup(object map[interface{}]interface{}, keys ...string) (interface{}, bool) {
+	var value interface{} = object
+	for _, key := range keys {
+		m, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
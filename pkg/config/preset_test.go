@@ -0,0 +1,229 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestApplyPresets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manager-deployment.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: manager
+spec:
+  replicas: 2
+  template:
+    spec:
+      nodeSelector:
+        kubernetes.io/os: linux
+      securityContext:
+        runAsNonRoot: true
+      containers:
+      - name: kube-rbac-proxy
+        image: gcr.io/kubebuilder/kube-rbac-proxy:v0.13.0
+      - name: manager
+        image: localhost:5000/controller@sha256:0123
+        imagePullPolicy: IfNotPresent
+        resources:
+          limits:
+            cpu: 500m
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cache-statefulset.yaml"), []byte(`apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: cache
+spec:
+  template:
+    spec:
+      nodeSelector:
+        disktype: ssd
+      imagePullSecrets: []
+      containers:
+      - name: memcached
+        image: memcached:1.6
+        resources:
+          limits:
+            memory: 64Mi
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manager-role.yaml"), []byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: manager
+rules: []
+`), 0644))
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig["apps/v1/Deployment//manager"] = Config{
+		"spec.replicas": []XPathConfig{{Strategy: XPathStrategyInline, Key: "replicaCount"}},
+	}
+	require.NoError(t, config.IndexManifests(dir))
+
+	changed, err := config.ApplyPresets([]string{PresetWorkloadStandard, PresetRBACToggle})
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.NoError(t, config.Validate())
+
+	deployment := config.FileConfig["apps/v1/Deployment//manager"]
+	// Configured XPaths are kept.
+	require.Equal(t, "replicaCount", deployment["spec.replicas"][0].Key)
+	require.Equal(t, XPathConfigs{
+		{Strategy: XPathStrategyInline, Key: "kubeRbacProxy.image.repository", Value: "gcr.io/kubebuilder/kube-rbac-proxy"},
+		{Strategy: XPathStrategyInline, Key: "kubeRbacProxy.image.tag", Value: "v0.13.0"},
+	}, deployment["spec.template.spec.containers[name=kube-rbac-proxy].image"])
+	require.Equal(t, XPathConfigs{
+		{Strategy: XPathStrategyInline, Key: "manager.image.repository", Value: "localhost:5000/controller@sha256:0123"},
+	}, deployment["spec.template.spec.containers[name=manager].image"])
+	require.Equal(t, "manager.image.pullPolicy", deployment["spec.template.spec.containers[name=manager].imagePullPolicy"][0].Key)
+	require.Equal(t, XPathStrategyNewlineYAML, deployment["spec.template.spec.containers[name=manager].resources"][0].Strategy)
+	require.Equal(t, "manager.resources", deployment["spec.template.spec.containers[name=manager].resources"][0].Key)
+	// Pod fields that no other workload sets differently refer to sharedValues.
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyControlWith, Key: "sharedValues.podSecurityContext"}}, deployment["spec.template.spec.securityContext"])
+	require.NotContains(t, deployment, XPath("spec.template.spec.tolerations"))
+	require.Equal(t, map[interface{}]interface{}{"runAsNonRoot": true}, config.SharedValues["podSecurityContext"])
+	require.Equal(t, GenericMap{}, config.SharedValues["tolerations"])
+	// The node selectors differ, and are kept per workload.
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyNewlineYAML, Key: "nodeSelector", Value: map[interface{}]interface{}{"kubernetes.io/os": "linux"}}}, deployment["spec.template.spec.nodeSelector"])
+	require.Equal(t, GenericMap{}, config.SharedValues["nodeSelector"])
+
+	// The resources of a single container are those of the workload.
+	statefulSet := config.FileConfig["apps/v1/StatefulSet//cache"]
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyControlWith, Key: "sharedValues.resources"}}, statefulSet["spec.template.spec.containers[name=memcached].resources"])
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyNewlineYAML, Key: "nodeSelector", Value: map[interface{}]interface{}{"disktype": "ssd"}}}, statefulSet["spec.template.spec.nodeSelector"])
+	require.Equal(t, map[interface{}]interface{}{"limits": map[interface{}]interface{}{"memory": "64Mi"}}, config.SharedValues["resources"])
+	// Keys that are not seeded are added, even if empty, for the rules to refer to.
+	require.Equal(t, []interface{}{}, config.SharedValues["imagePullSecrets"])
+
+	require.Equal(t, XPathConfigs{{Strategy: XPathStrategyFileIf, Key: "sharedValues.rbac.create"}}, config.FileConfig["rbac.authorization.k8s.io/v1/ClusterRole//manager"][XPathRoot])
+	require.Equal(t, map[interface{}]interface{}{"create": true}, config.SharedValues["rbac"])
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "rbac:\n  create: true\n")
+	require.Contains(t, values, "      repository: gcr.io/kubebuilder/kube-rbac-proxy\n      tag: v0.13.0\n")
+
+	// Applying the presets again changes nothing.
+	changed, err = config.ApplyPresets([]string{PresetWorkloadStandard, PresetRBACToggle})
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// Adding rbac.create to sharedValues is a change, even if the rules are configured already.
+	delete(config.SharedValues, "rbac")
+	changed, err = config.ApplyPresets([]string{PresetRBACToggle})
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, map[interface{}]interface{}{"create": true}, config.SharedValues["rbac"])
+
+	_, err = config.ApplyPresets([]string{"workload-standrd"})
+	require.ErrorContains(t, err, "unknown preset 'workload-standrd', did you mean 'workload-standard'?, must be one of rbac-toggle, workload-standard")
+}
+
+func TestApplyPresetsSharesEqualValues(t *testing.T) {
+	dir := t.TempDir()
+	for _, workload := range []struct{ name, pool string }{{"a", "gpu"}, {"b", "cpu"}} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, workload.name+"-deployment.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: `+workload.name+`
+spec:
+  template:
+    spec:
+      nodeSelector:
+        pool: `+workload.pool+`
+      tolerations:
+      - key: dedicated
+        operator: Exists
+      affinity:
+        podAntiAffinity: {}
+      containers:
+      - name: app
+        image: app:1.0
+`), 0644))
+	}
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.SharedValues["affinity"] = GenericMap{"nodeAffinity": GenericMap{}}
+	require.NoError(t, config.IndexManifests(dir))
+	_, err := config.ApplyPresets([]string{PresetWorkloadStandard})
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+
+	for _, workload := range []struct{ name, pool string }{{"a", "gpu"}, {"b", "cpu"}} {
+		deployment := config.FileConfig["apps/v1/Deployment//"+workload.name]
+		// Different values are kept per workload.
+		require.Equal(t, XPathConfigs{{Strategy: XPathStrategyNewlineYAML, Key: "nodeSelector", Value: map[interface{}]interface{}{"pool": workload.pool}}}, deployment["spec.template.spec.nodeSelector"])
+		// Equal values are shared.
+		require.Equal(t, XPathConfigs{{Strategy: XPathStrategyControlWith, Key: "sharedValues.tolerations"}}, deployment["spec.template.spec.tolerations"])
+		// sharedValues holds another value already.
+		require.Equal(t, "affinity", deployment["spec.template.spec.affinity"][0].Key)
+	}
+	require.Equal(t, []interface{}{map[interface{}]interface{}{"key": "dedicated", "operator": "Exists"}}, config.SharedValues["tolerations"])
+	require.Equal(t, GenericMap{}, config.SharedValues["nodeSelector"])
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "  nodeSelector:\n    pool: gpu\nbDeployment:\n")
+	require.Contains(t, values, "  nodeSelector:\n    pool: cpu\n")
+}
+
+func TestApplyPresetsWithIncludes(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "mychart-generated")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app-deployment.yaml"), []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app-role.yaml"), []byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: app
+rules: []
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "common.config"), []byte(`apiVersion: kustohelmize.io/v1
+sharedValues:
+  rbac:
+    create: false
+fileConfig:
+  apps/v1/Deployment//app:
+    spec["replicas"]:
+    - strategy: inline
+      key: replicaCount
+`), 0644))
+	path := filepath.Join(root, "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: kustohelmize.io/v1
+chartname: mychart
+include:
+- common.config
+`), 0644))
+
+	config, err := Load(zap.New(), path)
+	require.NoError(t, err)
+	require.NoError(t, config.IndexManifests(dir))
+	changed, err := config.ApplyPresets([]string{PresetWorkloadStandard, PresetRBACToggle})
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	// What the included file configures is left to it.
+	deployment := config.FileConfig["apps/v1/Deployment//app"]
+	require.NotContains(t, deployment, XPath("spec.replicas"))
+	require.Contains(t, deployment, XPath("spec.template.spec.containers[name=app].image"))
+	require.NotContains(t, config.SharedValues, "rbac")
+	require.Contains(t, config.FileConfig["rbac.authorization.k8s.io/v1/Role//app"], XPath(XPathRoot))
+
+	merged, err := config.WithIncludes()
+	require.NoError(t, err)
+	require.NoError(t, merged.Validate())
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+	xpathConfigDefinition = "xpathConfig"
+)
+
+// Descriptions of the properties, keyed by <Go type name>.<property name>.
+var schemaDescriptions = map[string]string{
+	"ChartConfig.logger":         "Not used. Written as an empty map by older versions of kustohelmize, removed by config migrate.",
+	"ChartConfig.apiVersion":     "The format of the configuration file. Files without it are upgraded by config migrate.",
+	"ChartConfig.chartname":      "The name of the Helm chart.",
+	"ChartConfig.include":        "Configuration files merged into this one, relative to this file. Later files, and this file last, take precedence.",
+	"ChartConfig.namingTemplate": "A Go template that names the intermediate files. It can refer to .apiVersion, .kind, .name and .namespace, and use the lower, upper and short functions.",
+	"ChartConfig.sharedValues":   "User-defined values that are shared within the Helm chart, referred to as sharedValues.<path>.",
+	"ChartConfig.globalConfig":   "XPath configurations applied to all templates.",
+	"ChartConfig.selectorConfig": "XPath configurations applied to the resources matching a selector. They take precedence over globalConfig, and fileConfig takes precedence over them.",
+	"ChartConfig.fileConfig":     "XPath configurations per resource, keyed by apiVersion/kind/namespace/name or by intermediate file path.",
+
+	"SelectorConfig.selector": "The resources the configuration applies to. Every property that is set must match.",
+	"SelectorConfig.config":   "XPath configurations applied to the matching resources. When several selectors configure the same XPath, the last one wins.",
+
+	"Selector.apiVersion": "The apiVersion of the resources, e.g. apps/v1.",
+	"Selector.kinds":      "The kinds of the resources, any of which matches.",
+	"Selector.name":       "A shell pattern matching the name of the resources, e.g. *-controller-manager.",
+	"Selector.labels":     "Labels the resources must all have.",
+
+	"XPathConfig.strategy":          "How the value at the XPath is templated.",
+	"XPathConfig.key":               "The values key, a sharedValues.<path> key or a named template from _helpers.tpl.",
+	"XPathConfig.value":             "The value written to values.yaml for key.",
+	"XPathConfig.defaultValue":      "The value passed to the default function of the template.",
+	"XPathConfig.regex":             "A regular expression with exactly one capture group, which is replaced by key.",
+	"XPathConfig.conditions":        "The values keys that must be true (or false with a leading !) to emit the value.",
+	"XPathConfig.conditionOperator": "How multiple conditions are combined.",
+	"XPathConfig.inferred":          "Whether the configuration was inferred by create --infer and has not been reviewed yet.",
+	"XPathConfig.condition":         "Deprecated: use conditions instead.",
+	"XPathConfig.conditionValue":    "Deprecated: use conditions instead.",
+
+	"Condition.key":   "The values key of the condition, negated with a leading !.",
+	"Condition.value": "The value written to values.yaml for key.",
+}
+
+// Properties that are still accepted but should no longer be used.
+var schemaDeprecated = map[string]bool{
+	"ChartConfig.logger":         true,
+	"XPathConfig.condition":      true,
+	"XPathConfig.conditionValue": true,
+}
+
+var (
+	xpathStrategyType = reflect.TypeOf(XPathStrategy(""))
+	xpathConfigType   = reflect.TypeOf(XPathConfig{})
+)
+
+// JSONSchema returns a JSON Schema (draft-07) describing the chart configuration file.
+func JSONSchema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(ChartConfig{}))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "kustohelmize chart configuration"
+	schema["required"] = []string{"chartname"}
+	schema["definitions"] = map[string]interface{}{
+		xpathConfigDefinition: xpathConfigSchema(),
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	properties["apiVersion"].(map[string]interface{})["enum"] = []string{APIVersion}
+	// Files written by older versions have the logger, which is no longer part of ChartConfig.
+	properties["logger"] = map[string]interface{}{
+		"type":        "object",
+		"description": schemaDescriptions["ChartConfig.logger"],
+		"deprecated":  schemaDeprecated["ChartConfig.logger"],
+	}
+	// A root level config only makes sense for file-if, which is per file.
+	properties["globalConfig"].(map[string]interface{})["propertyNames"] = map[string]interface{}{"minLength": 1}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// XPathConfig is shared by globalConfig and fileConfig, so it is defined once.
+func xpathConfigSchema() map[string]interface{} {
+	schema := structSchema(xpathConfigType)
+	schema["required"] = []string{"strategy"}
+	schema["allOf"] = strategyRules()
+	properties := schema["properties"].(map[string]interface{})
+	properties["conditionOperator"].(map[string]interface{})["enum"] = []string{ConditionOperatorAnd, ConditionOperatorOr}
+	return schema
+}
+
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case xpathStrategyType:
+		enum := make([]string, len(XPathStrategies))
+		for i, strategy := range XPathStrategies {
+			enum[i] = string(strategy)
+		}
+		return map[string]interface{}{"type": "string", "enum": enum}
+	case xpathConfigType:
+		return map[string]interface{}{"$ref": "#/definitions/" + xpathConfigDefinition}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		// interface{} accepts any value.
+		return map[string]interface{}{}
+	}
+}
+
+// Describe the fields of a struct the way yaml.v2 (un)marshals them.
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := yamlFieldName(field)
+		if !ok {
+			continue
+		}
+
+		property := schemaFor(field.Type)
+		if description, ok := schemaDescriptions[t.Name()+"."+name]; ok {
+			property["description"] = description
+		}
+		if schemaDeprecated[t.Name()+"."+name] {
+			property["deprecated"] = true
+		}
+		properties[name] = property
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// The name yaml.v2 (un)marshals a field with, if it does.
+func yamlFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+// The properties each strategy requires or does not allow, mirroring ChartConfig.Validate.
+func strategyRules() []interface{} {
+	strategyIs := func(strategies ...XPathStrategy) map[string]interface{} {
+		enum := make([]string, len(strategies))
+		for i, strategy := range strategies {
+			enum[i] = string(strategy)
+		}
+		return map[string]interface{}{
+			"required":   []string{"strategy"},
+			"properties": map[string]interface{}{"strategy": map[string]interface{}{"enum": enum}},
+		}
+	}
+
+	conditional := []XPathStrategy{XPathStrategyControlIf, XPathStrategyControlIfYAML}
+	unconditional := []XPathStrategy{}
+	for _, strategy := range XPathStrategies {
+		if strategy != XPathStrategyControlIf && strategy != XPathStrategyControlIfYAML {
+			unconditional = append(unconditional, strategy)
+		}
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"if":   strategyIs(XPathStrategyInlineRegex),
+			"then": map[string]interface{}{"required": []string{"regex"}},
+		},
+		map[string]interface{}{
+			"if": strategyIs(unconditional...),
+			"then": map[string]interface{}{
+				"not": map[string]interface{}{
+					"anyOf": []interface{}{
+						map[string]interface{}{"required": []string{"condition"}},
+						map[string]interface{}{"required": []string{"conditions"}},
+					},
+				},
+			},
+		},
+		map[string]interface{}{
+			"if": map[string]interface{}{
+				"allOf": []interface{}{
+					strategyIs(conditional...),
+					map[string]interface{}{
+						"required":   []string{"conditions"},
+						"properties": map[string]interface{}{"conditions": map[string]interface{}{"minItems": 2}},
+					},
+				},
+			},
+			"then": map[string]interface{}{"required": []string{"conditionOperator"}},
+		},
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+func validateWithSchema(t *testing.T, document string) *gojsonschema.Result {
+	schema, err := JSONSchema()
+	require.NoError(t, err)
+
+	var data interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(document), &data))
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewGoLoader(data))
+	require.NoError(t, err)
+	return result
+}
+
+func TestJSONSchemaAcceptsExistingConfigs(t *testing.T) {
+	paths, err := filepath.Glob("../../test/output/*/*.config")
+	require.NoError(t, err)
+	paths = append(paths, "../../examples/memcached-operator/deployments/memcached-operator.config")
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		bs, err := os.ReadFile(path)
+		require.NoError(t, err)
+		result := validateWithSchema(t, string(bs))
+		require.True(t, result.Valid(), "%s: %v", path, result.Errors())
+	}
+}
+
+func TestJSONSchemaRejectsInvalidConfigs(t *testing.T) {
+	tests := map[string]string{
+		"unknown strategy": `
+chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.replicas:
+    - strategy: inlined
+      key: replicas
+`,
+		"inline-regex without regex": `
+chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.template.spec.containers[0].args:
+    - strategy: inline-regex
+      key: port
+`,
+		"conditions without operator": `
+chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.replicas:
+    - strategy: control-if
+      conditions:
+      - key: sharedValues.a
+      - key: sharedValues.b
+`,
+		"unknown operator": `
+chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.replicas:
+    - strategy: control-if
+      conditions:
+      - key: sharedValues.a
+      - key: sharedValues.b
+      conditionOperator: xor
+`,
+		"condition on inline": `
+chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.replicas:
+    - strategy: inline
+      key: replicas
+      condition: sharedValues.a
+`,
+		"unknown property": `
+chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.replicas:
+    - strategy: inline
+      keys: replicas
+`,
+		"root level global config": `
+chartname: mychart
+globalConfig:
+  "":
+  - strategy: file-if
+    key: enabled
+`,
+		"missing chartname": `
+fileConfig: {}
+`,
+	}
+	for name, document := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.False(t, validateWithSchema(t, document).Valid())
+		})
+	}
+}
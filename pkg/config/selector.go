@@ -0,0 +1,67 @@
+package config
+
+import (
+	"path"
+
+	"github.com/yeahdongcn/kustohelmize/pkg/manifest"
+)
+
+// Selector matches resources by kind, apiVersion, name pattern and labels.
+// Every field that is set must match, so an empty selector matches every resource.
+type Selector struct {
+	APIVersion string            `yaml:"apiVersion,omitempty"`
+	Kinds      []string          `yaml:"kinds,omitempty"`
+	Name       string            `yaml:"name,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+}
+
+// SelectorConfig applies XPath configurations to every resource matched by its selector.
+type SelectorConfig struct {
+	Selector Selector `yaml:"selector"`
+	Config   Config   `yaml:"config"`
+}
+
+// Matches reports whether the resource is selected. Name is a shell pattern as in path.Match.
+func (s *Selector) Matches(resource *manifest.Resource) bool {
+	if s.APIVersion != "" && s.APIVersion != resource.APIVersion {
+		return false
+	}
+	if len(s.Kinds) > 0 {
+		found := false
+		for _, kind := range s.Kinds {
+			if kind == resource.Kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.Name != "" {
+		if matched, err := path.Match(s.Name, resource.Name); err != nil || !matched {
+			return false
+		}
+	}
+	for key, value := range s.Labels {
+		if v, ok := resource.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge the configurations of the selectors matching the resource. When several selectors
+// configure the same XPath, the one listed last wins.
+func (c *ChartConfig) selectedConfig(resource *manifest.Resource) Config {
+	config := Config{}
+	for _, selectorConfig := range c.SelectorConfig {
+		if !selectorConfig.Selector.Matches(resource) {
+			continue
+		}
+		for xpath, xpathConfigs := range selectorConfig.Config {
+			config[xpath] = xpathConfigs
+		}
+	}
+	return config
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/yeahdongcn/kustohelmize/pkg/manifest"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	resource := &manifest.Resource{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       "nginx-controller-manager",
+		Labels:     map[string]string{"app": "nginx", "tier": "web"},
+	}
+
+	tests := []struct {
+		selector Selector
+		matches  bool
+	}{
+		{Selector{}, true},
+		{Selector{Kinds: []string{"StatefulSet", "Deployment"}}, true},
+		{Selector{Kinds: []string{"StatefulSet"}}, false},
+		{Selector{APIVersion: "apps/v1"}, true},
+		{Selector{APIVersion: "v1"}, false},
+		{Selector{Name: "*-controller-manager"}, true},
+		{Selector{Name: "nginx"}, false},
+		{Selector{Name: "["}, false},
+		{Selector{Labels: map[string]string{"app": "nginx"}}, true},
+		{Selector{Labels: map[string]string{"app": "nginx", "tier": "db"}}, false},
+		{Selector{Kinds: []string{"Deployment"}, Labels: map[string]string{"team": "a"}}, false},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.matches, test.selector.Matches(resource), "%+v", test.selector)
+	}
+}
+
+func TestSelectorConfig(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+	deployment := filepath.Join(dir, "nginx-deployment.yaml")
+	service := filepath.Join(dir, "nginx-svc.yaml")
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.SelectorConfig = []SelectorConfig{
+		{
+			Selector: Selector{Labels: map[string]string{"app": "nginx"}},
+			Config: Config{
+				"spec.replicas": []XPathConfig{{Strategy: XPathStrategyInline, Key: "replicas", Value: 1}},
+			},
+		},
+		{
+			Selector: Selector{Kinds: []string{"Service"}, Name: "nginx-*"},
+			Config: Config{
+				"spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "type", Value: "ClusterIP"}},
+			},
+		},
+		{
+			Selector: Selector{APIVersion: "apps/v1", Kinds: []string{"Deployment", "StatefulSet"}},
+			Config: Config{
+				"spec.replicas": []XPathConfig{{Strategy: XPathStrategyInline, Key: "replicaCount", Value: 2}},
+			},
+		},
+	}
+	config.FileConfig["v1/Service/web/nginx-metrics"] = Config{
+		"spec.type": []XPathConfig{{Strategy: XPathStrategyInline, Key: "metrics.type", Value: "NodePort"}},
+	}
+	require.NoError(t, config.IndexManifests(dir))
+	require.NoError(t, config.Validate())
+
+	// The last matching selector wins.
+	require.Equal(t, "replicaCount", documentConfig(t, config, deployment, 0)["spec.replicas"][0].Key)
+	require.Empty(t, documentConfig(t, config, service, 0))
+	// fileConfig takes precedence over selectors.
+	require.Equal(t, "metrics.type", documentConfig(t, config, service, 1)["spec.type"][0].Key)
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "nginxDeployment:\n  replicaCount: 2\n")
+	require.NotContains(t, values, "replicas:")
+	require.Contains(t, values, "nginxSvc:\n  metrics:\n    type: NodePort\n")
+	require.NotContains(t, values, "  type: ClusterIP\n")
+}
+
+func TestValidateSelectorConfig(t *testing.T) {
+	config := NewChartConfig(zap.New(), "mychart")
+	config.SelectorConfig = []SelectorConfig{
+		{
+			Selector: Selector{Name: "["},
+			Config: Config{
+				"spec.replicas": []XPathConfig{{Strategy: "inlne", Key: "replicas"}},
+			},
+		},
+	}
+	err := config.Validate()
+	require.ErrorContains(t, err, "'selectorConfig[0]' invalid name pattern '['")
+	require.ErrorContains(t, err, "'selectorConfig[0]' unknown strategy 'inlne' at 'spec.replicas', did you mean 'inline'?")
+}
+
+func TestSelectorConfigValues(t *testing.T) {
+	dir := writeIntermediateFiles(t)
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.SelectorConfig = []SelectorConfig{
+		{
+			Selector: Selector{Kinds: []string{"Deployment", "Service"}},
+			Config: Config{
+				"metadata.labels.app": []XPathConfig{{Strategy: XPathStrategyInline, Key: "app", Value: "nginx"}},
+				"spec.type":           []XPathConfig{{Strategy: XPathStrategyInline, Key: "type", Value: "ClusterIP"}},
+			},
+		},
+	}
+	// The same XPath as the selector's, spelled differently.
+	config.FileConfig["v1/Service/web/nginx-metrics"] = Config{
+		`spec["type"]`: []XPathConfig{{Strategy: XPathStrategyInline, Key: "metrics.type", Value: "NodePort"}},
+	}
+	require.NoError(t, config.IndexManifests(dir))
+	require.NoError(t, config.Validate())
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	// Only the Deployment has labels, and only the Services have a type.
+	require.Contains(t, values, "nginxDeployment:\n  app: nginx\nnginxSvc:\n")
+	require.Contains(t, values, "nginxSvc:\n  metrics:\n    type: NodePort\n  type: ClusterIP\n")
+	require.NotContains(t, values, "  app: nginx\n  type")
+}
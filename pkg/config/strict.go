@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/yeahdongcn/kustohelmize/pkg/util"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var chartConfigType = reflect.TypeOf(ChartConfig{})
+
+// Report the problems that would otherwise be silently ignored when the file at path is decoded,
+// or only fail when the templates are processed: fields that the configuration does not have, and
+// unknown strategies. The file is decoded from migrated, the original content of which is located
+// by root.
+func strictDiagnostics(path string, root *yamlv3.Node, migrated []byte, c *ChartConfig) []Diagnostic {
+	node := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(migrated, node); err != nil {
+		return []Diagnostic{yamlDiagnostic(path, err)}
+	}
+	problems := unknownFields(node, chartConfigType, nil)
+	problems = append(problems, c.strategyProblems()...)
+	return diagnose(path, root, problems)
+}
+
+// Turn strict diagnostics into an error, one line per diagnostic.
+func strictError(diagnostics []Diagnostic) error {
+	errs := make([]error, len(diagnostics))
+	for i, diagnostic := range diagnostics {
+		errs[i] = errors.New(diagnostic.String())
+	}
+	return errors.Join(errs...)
+}
+
+// Report the keys of the mappings of node that are not fields of the type t, recursively.
+// Values without a type, such as sharedValues and the values of XPathConfigs, accept any key.
+func unknownFields(node *yamlv3.Node, t reflect.Type, path []string) []problem {
+	if node == nil {
+		return nil
+	}
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return unknownFields(node.Content[0], t, path)
+	case yamlv3.AliasNode:
+		return unknownFields(node.Alias, t, path)
+	}
+
+	problems := []problem{}
+	at := func(key string) []string {
+		return append(append([]string{}, path...), key)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return unknownFields(node, t.Elem(), path)
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		names := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := yamlFieldName(t.Field(i)); ok {
+				fields[name] = t.Field(i).Type
+				names = append(names, name)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			fieldType, ok := fields[key]
+			if !ok {
+				problems = append(problems, problem{Path: at(key), Message: unknownFieldMessage(path, key, names), Key: true})
+				continue
+			}
+			problems = append(problems, unknownFields(node.Content[i+1], fieldType, at(key))...)
+		}
+	case reflect.Map:
+		if node.Kind != yamlv3.MappingNode || t.Elem().Kind() == reflect.Interface {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			problems = append(problems, unknownFields(node.Content[i+1], t.Elem(), at(node.Content[i].Value))...)
+		}
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			return nil
+		}
+		for i, item := range node.Content {
+			problems = append(problems, unknownFields(item, t.Elem(), at(strconv.Itoa(i)))...)
+		}
+	}
+	return problems
+}
+
+// Describe an unknown field at path, naming the XPath it configures if any, the way the other
+// problems of XPathConfigs are reported.
+func unknownFieldMessage(path []string, field string, known []string) string {
+	var message string
+	if name, xpath, ok := configLocation(path); ok {
+		message = fmt.Sprintf("'%s' unknown field '%s' at '%s'", name, field, xpath)
+	} else if len(path) > 0 {
+		message = fmt.Sprintf("unknown field '%s' in '%s'", field, strings.Join(path, "."))
+	} else {
+		message = fmt.Sprintf("unknown field '%s'", field)
+	}
+	if match, ok := util.ClosestMatch(field, known); ok {
+		message += fmt.Sprintf(", did you mean '%s'?", match)
+	}
+	return message
+}
+
+// Find the name of the section and the XPath of a path within the XPathConfigs of an XPath.
+func configLocation(path []string) (string, string, bool) {
+	switch {
+	case len(path) >= 3 && path[0] == sectionGlobalConfig:
+		return sectionGlobalConfig, path[1], true
+	case len(path) >= 5 && path[0] == sectionSelectorConfig && path[2] == "config":
+		return fmt.Sprintf("%s[%s]", sectionSelectorConfig, path[1]), path[3], true
+	case len(path) >= 4 && path[0] == sectionFileConfig:
+		return path[1], path[2], true
+	}
+	return "", "", false
+}
+
+// Unknown strategies, which are also reported by Validate.
+func (c *ChartConfig) strategyProblems() []problem {
+	problems := []problem{}
+	c.eachConfig(func(path []string, name string, config Config) {
+		for _, xpath := range sortedXPaths(config) {
+			for i, xpathConfig := range config[xpath] {
+				if !isKnownStrategy(xpathConfig.Strategy) {
+					problems = append(problems, problem{
+						Path:    append(append([]string{}, path...), string(xpath), strconv.Itoa(i), "strategy"),
+						Message: unknownStrategyMessage(name, xpathConfig.Strategy, xpath),
+					})
+				}
+			}
+		}
+	})
+	return problems
+}
+
+func unknownStrategyMessage(name string, strategy XPathStrategy, xpath XPath) string {
+	if strategy == "" {
+		return fmt.Sprintf("'%s' must have 'strategy' property at '%s'", name, xpath)
+	}
+	message := fmt.Sprintf("'%s' unknown strategy '%s' at '%s'", name, strategy, xpath)
+	if match, ok := util.ClosestMatch(string(strategy), strategyNames()); ok {
+		message += fmt.Sprintf(", did you mean '%s'?", match)
+	}
+	return message
+}
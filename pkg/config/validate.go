@@ -0,0 +1,339 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/dlclark/regexp2"
+	"github.com/yeahdongcn/kustohelmize/pkg/manifest"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	sectionGlobalConfig = "globalConfig"
+	sectionFileConfig   = "fileConfig"
+
+	sectionSelectorConfig = "selectorConfig"
+	sectionInclude        = "include"
+)
+
+// problem is an issue found in a configuration. Path holds the keys (and list indexes)
+// leading to the offending node, e.g. fileConfig, <manifest>, <xpath>, 0, regex.
+type problem struct {
+	Path    []string
+	Message string
+	// Key locates the problem at the key of the node at Path rather than at the node, as for
+	// unknown fields.
+	Key bool
+}
+
+// Diagnostic is a problem located in a configuration file.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// yaml.v2 reports syntax and type errors as "line N: message".
+var yamlErrorRegex = regexp.MustCompile(`line (\d+): (.*)`)
+
+// ValidateFile reads the configuration file at path and reports every problem found in it.
+// The error is only set if the file cannot be read.
+func ValidateFile(path string) ([]Diagnostic, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(bs, root); err != nil {
+		return []Diagnostic{yamlDiagnostic(path, err)}, nil
+	}
+
+	// Older formats are checked for the fields of the current one.
+	document := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(bs, &document); err != nil {
+		return []Diagnostic{yamlDiagnostic(path, err)}, nil
+	}
+	migrated := bs
+	if changes, err := migrate(document); err != nil {
+		return []Diagnostic{{File: path, Message: err.Error()}}, nil
+	} else if len(changes) > 0 {
+		if migrated, err = yaml.Marshal(document); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &ChartConfig{}
+	if err := yaml.Unmarshal(bs, c); err != nil {
+		diagnostics := []Diagnostic{}
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, e := range typeErr.Errors {
+				diagnostics = append(diagnostics, yamlDiagnostic(path, fmt.Errorf("%s", e)))
+			}
+			return diagnostics, nil
+		}
+		return []Diagnostic{yamlDiagnostic(path, err)}, nil
+	}
+
+	c.path = path
+	node := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(migrated, node); err != nil {
+		return []Diagnostic{yamlDiagnostic(path, err)}, nil
+	}
+	problems := unknownFields(node, chartConfigType, nil)
+	problems = append(problems, c.problems()...)
+	problems = append(problems, c.includeProblems()...)
+	return diagnose(path, root, problems), nil
+}
+
+// Locate the problems of the file at path, whose content is root, sorted by position.
+func diagnose(path string, root *yamlv3.Node, problems []problem) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, problem := range problems {
+		line, column := position(root, problem.Path, problem.Key)
+		diagnostics = append(diagnostics, Diagnostic{
+			File:    path,
+			Line:    line,
+			Column:  column,
+			Message: problem.Message,
+		})
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	return diagnostics
+}
+
+func yamlDiagnostic(path string, err error) Diagnostic {
+	diagnostic := Diagnostic{File: path, Message: err.Error()}
+	if m := yamlErrorRegex.FindStringSubmatch(err.Error()); m != nil {
+		diagnostic.Line, _ = strconv.Atoi(m[1])
+		diagnostic.Message = m[2]
+	}
+	return diagnostic
+}
+
+// Find the line and column of the node at path, or of its closest existing parent. The key of the
+// node is located instead if key is set and the node is the value of a map.
+func position(root *yamlv3.Node, path []string, key bool) (int, int) {
+	node := root
+	if node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line, column := node.Line, node.Column
+	for depth, name := range path {
+		var keyNode, valueNode *yamlv3.Node
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == name {
+					keyNode, valueNode = node.Content[i], node.Content[i+1]
+					break
+				}
+			}
+		case yamlv3.SequenceNode:
+			if i, err := strconv.Atoi(name); err == nil && i < len(node.Content) {
+				valueNode = node.Content[i]
+			}
+		}
+		if valueNode == nil {
+			break
+		}
+		// Point at the key of a collection, and at the value of a scalar.
+		if keyNode != nil && (valueNode.Kind != yamlv3.ScalarNode || key && depth == len(path)-1) {
+			line, column = keyNode.Line, keyNode.Column
+		} else {
+			line, column = valueNode.Line, valueNode.Column
+		}
+		node = valueNode
+	}
+	return line, column
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func sortedXPaths(config Config) []XPath {
+	xpaths := make([]XPath, 0, len(config))
+	for xpath := range config {
+		xpaths = append(xpaths, xpath)
+	}
+	sort.Slice(xpaths, func(i, j int) bool { return xpaths[i] < xpaths[j] })
+	return xpaths
+}
+
+// Collect the problems of the configuration. Regular expressions are compiled along the way.
+//
+// Validates
+// - file-if can only be present at root level file configs
+// - globalConfig cannot contain a root level entry
+// - selector name patterns must be valid
+// - XPaths must be well-formed
+// - strategies must be known
+// - inline-regex must have regex property, and the regex must compile and contain exactly one capture group
+// - control-if and control-if-yaml with multiple conditions:
+//   - must have conditionOperator property
+//   - conditionOperator must be 'and' or 'or'
+//
+// - other strategies cannot have condition or conditions property
+// - namingTemplate must be a valid template
+// - apiVersion must be the current one, if set
+func (c *ChartConfig) problems() []problem {
+	problems := []problem{}
+	report := func(path []string, format string, args ...interface{}) {
+		problems = append(problems, problem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.APIVersion != "" && c.APIVersion != APIVersion {
+		report([]string{"apiVersion"}, "unsupported apiVersion '%s', must be '%s'", c.APIVersion, APIVersion)
+	}
+	if _, err := manifest.NewNamer(c.NamingTemplate); err != nil {
+		report([]string{"namingTemplate"}, "%s", err)
+	}
+	if _, ok := c.GlobalConfig[XPathRoot]; ok {
+		report([]string{sectionGlobalConfig, XPathRoot}, "cannot have root level config in GlobalConfig")
+	}
+
+	check := func(path []string, name string, config Config) {
+		for _, xpath := range sortedXPaths(config) {
+			xpathConfigs := config[xpath]
+			if _, local := xpath.Document(); !local.IsRoot() {
+				if _, err := local.segments(); err != nil {
+					report(append(append([]string{}, path...), string(xpath)), "'%s' %s", name, err)
+					continue
+				}
+			}
+			for i, xpathConfig := range xpathConfigs {
+				at := func(keys ...string) []string {
+					p := append([]string{}, path...)
+					p = append(p, string(xpath), strconv.Itoa(i))
+					return append(p, keys...)
+				}
+				strategy := xpathConfig.Strategy
+				if !isKnownStrategy(strategy) {
+					report(at("strategy"), "%s", unknownStrategyMessage(name, strategy, xpath))
+					continue
+				}
+				if _, local := xpath.Document(); local.IsRoot() != (strategy == XPathStrategyFileIf) {
+					report(at("strategy"), "'%s' cannot use strategy '%s' at '%s'", name, strategy, xpath)
+				}
+				if strategy == XPathStrategyInlineRegex {
+					if xpathConfig.Regex == "" {
+						report(at(), "'%s' strategy '%s' must have 'regex' property", name, strategy)
+						continue
+					}
+					rx, err := regexp2.Compile(xpathConfig.Regex, regexp2.Compiled)
+					if err != nil {
+						report(at("regex"), "'%s' strategy '%s': invalid regular expression '%s': %s", name, strategy, xpathConfig.Regex, err)
+						continue
+					}
+					if len(rx.GetGroupNumbers()) != 2 {
+						// groups[0] is the entire match. groups[1] is the bit within ()
+						report(at("regex"), "'%s' strategy '%s': regular expression '%s' must have exactly one replacement group", name, strategy, xpathConfig.Regex)
+						continue
+					}
+					xpathConfigs[i].RegexCompiled = rx
+				} else if strategy == XPathStrategyControlIf || strategy == XPathStrategyControlIfYAML {
+					if len(xpathConfig.Conditions) > 1 {
+						if xpathConfig.ConditionOperator == nil {
+							report(at("conditions"), "'%s' strategy '%s' must have 'conditionOperator' property", name, strategy)
+						}
+					}
+					if xpathConfig.ConditionOperator != nil {
+						if *xpathConfig.ConditionOperator != ConditionOperatorAnd && *xpathConfig.ConditionOperator != ConditionOperatorOr {
+							report(at("conditionOperator"), "'%s' strategy '%s' conditionOperator must be 'and' or 'or'", name, strategy)
+						}
+					}
+				} else {
+					if xpathConfig.Condition != "" {
+						report(at("condition"), "'%s' strategy '%s' cannot have 'condition' or 'conditions' property", name, strategy)
+					} else if len(xpathConfig.Conditions) > 0 {
+						report(at("conditions"), "'%s' strategy '%s' cannot have 'condition' or 'conditions' property", name, strategy)
+					}
+				}
+			}
+		}
+	}
+
+	for i, selectorConfig := range c.SelectorConfig {
+		if _, err := path.Match(selectorConfig.Selector.Name, ""); err != nil {
+			report([]string{sectionSelectorConfig, strconv.Itoa(i), "selector", "name"}, "'%s[%d]' invalid name pattern '%s': %s", sectionSelectorConfig, i, selectorConfig.Selector.Name, err)
+		}
+	}
+	c.eachConfig(check)
+
+	return problems
+}
+
+// Visit the XPath configurations of every section with their path in the file and the name
+// problems are reported with.
+func (c *ChartConfig) eachConfig(visit func(path []string, name string, config Config)) {
+	visit([]string{sectionGlobalConfig}, sectionGlobalConfig, c.GlobalConfig)
+	for i, selectorConfig := range c.SelectorConfig {
+		visit([]string{sectionSelectorConfig, strconv.Itoa(i), "config"}, fmt.Sprintf("%s[%d]", sectionSelectorConfig, i), selectorConfig.Config)
+	}
+	for _, manifest := range sortedKeys(c.FileConfig) {
+		visit([]string{sectionFileConfig, manifest}, manifest, c.FileConfig[manifest])
+	}
+}
+
+// Included files must exist and be readable configurations.
+func (c *ChartConfig) includeProblems() []problem {
+	problems := []problem{}
+	for i, include := range c.Include {
+		path := include
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(c.path), path)
+		}
+		if _, err := Load(c.Logger, path); err != nil {
+			problems = append(problems, problem{
+				Path:    []string{sectionInclude, strconv.Itoa(i)},
+				Message: fmt.Sprintf("include '%s': %s", include, err),
+			})
+		}
+	}
+	return problems
+}
+
+func isKnownStrategy(strategy XPathStrategy) bool {
+	for _, s := range XPathStrategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+func strategyNames() []string {
+	names := make([]string, len(XPathStrategies))
+	for i, strategy := range XPathStrategies {
+		names[i] = string(strategy)
+	}
+	return names
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestValidateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`chartname: mychart
+sharedValues: {}
+globalConfig:
+  metadata.name:
+  - strategy: inline
+    key: mychart.fullname
+fileConfig:
+  mychart-generated/nginx-deployment.yaml:
+    "":
+    - strategy: file-if
+      key: nginx.enabled
+    spec.replicas:
+    - strategy: inlne
+      key: replicas
+    spec.template.spec.containers[0].args:
+    - strategy: inline-regex
+      key: port
+      regex: --port=\d+
+    spec.template.spec.nodeSelector:
+    - strategy: control-if
+      conditions:
+      - key: a
+      - key: b
+`), 0644))
+
+	diagnostics, err := ValidateFile(path)
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 3)
+
+	require.Equal(t, 13, diagnostics[0].Line)
+	require.Equal(t, 17, diagnostics[0].Column)
+	require.Contains(t, diagnostics[0].Message, "unknown strategy 'inlne'")
+	require.Contains(t, diagnostics[0].Message, "did you mean 'inline'?")
+	require.Equal(t, path+":13:17: "+diagnostics[0].Message, diagnostics[0].String())
+
+	require.Equal(t, 18, diagnostics[1].Line)
+	require.Equal(t, 14, diagnostics[1].Column)
+	require.Contains(t, diagnostics[1].Message, "must have exactly one replacement group")
+
+	require.Equal(t, 21, diagnostics[2].Line)
+	require.Equal(t, 7, diagnostics[2].Column)
+	require.Contains(t, diagnostics[2].Message, "must have 'conditionOperator' property")
+}
+
+func TestValidateFileReportsYAMLErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`chartname: mychart
+fileConfig:
+  a.yaml:
+    spec.replicas:
+    - strategy: inline
+      conditions: yes
+`), 0644))
+
+	diagnostics, err := ValidateFile(path)
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 1)
+	require.Equal(t, 6, diagnostics[0].Line)
+	require.Contains(t, diagnostics[0].Message, "cannot unmarshal")
+
+	_, err = ValidateFile(filepath.Join(t.TempDir(), "missing.config"))
+	require.Error(t, err)
+}
+
+func TestLoadIsStrict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mychart.config")
+	require.NoError(t, os.WriteFile(path, []byte(`chartname: mychart
+namingTemplte: "{{ .name }}"
+sharedValues:
+  anything: {goes: here}
+globalConfig:
+  metadata.name:
+  - stratgy: inline
+    key: mychart.fullname
+selectorConfig:
+- selector:
+    kind: Deployment
+  config:
+    spec.replicas:
+    - strategy: control_if
+      key: replicas
+fileConfig:
+  apps/v1/Deployment//nginx:
+    spec.replicas:
+    - strategy: control-if
+      key: replicas
+      value: {any: value}
+      conditions:
+      - key: enabled
+        vaule: true
+`), 0644))
+
+	_, err := Load(zap.New(), path)
+	require.Error(t, err)
+	require.Equal(t, path+`:2:1: unknown field 'namingTemplte', did you mean 'namingTemplate'?
+`+path+`:7:5: 'globalConfig' unknown field 'stratgy' at 'metadata.name', did you mean 'strategy'?
+`+path+`:7:5: 'globalConfig' must have 'strategy' property at 'metadata.name'
+`+path+`:11:5: unknown field 'kind' in 'selectorConfig.0.selector', did you mean 'kinds'?
+`+path+`:14:17: 'selectorConfig[0]' unknown strategy 'control_if' at 'spec.replicas', did you mean 'control-if'?
+`+path+`:24:9: 'apps/v1/Deployment//nginx' unknown field 'vaule' at 'spec.replicas'`, err.Error())
+
+	diagnostics, err := ValidateFile(path)
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 6)
+
+	// Fields of older formats are accepted, and migrated.
+	require.NoError(t, os.WriteFile(path, []byte(`logger: {}
+chartname: mychart
+globalConfig:
+  spec.replicas:
+  - strategy: control-if
+    key: replicas
+    condition: enabled
+`), 0644))
+	_, err = Load(zap.New(), path)
+	require.NoError(t, err)
+	diagnostics, err = ValidateFile(path)
+	require.NoError(t, err)
+	require.Empty(t, diagnostics)
+}
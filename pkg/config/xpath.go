@@ -0,0 +1,465 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+	"github.com/yeahdongcn/kustohelmize/pkg/util"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+type segmentKind int
+
+const (
+	segmentKey segmentKind = iota
+	segmentIndex
+	segmentWildcard
+	segmentSelector
+)
+
+// segment is a step of an XPath: a map key, a list index, a list wildcard or a list selector.
+// A selector picks the element whose field key has the value value, as in containers[name=manager].
+type segment struct {
+	kind  segmentKind
+	key   string
+	value string
+	index int
+}
+
+func (s segment) String() string {
+	switch s.kind {
+	case segmentIndex:
+		return fmt.Sprintf("[%d]", s.index)
+	case segmentWildcard:
+		return "[" + XPathWildcard + "]"
+	case segmentSelector:
+		if strings.ContainsAny(s.value, `]"`) {
+			return fmt.Sprintf("[%s%s%s]", s.key, XPathSelectorOperator, strconv.Quote(s.value))
+		}
+		return fmt.Sprintf("[%s%s%s]", s.key, XPathSelectorOperator, s.value)
+	default:
+		if needsQuote(s.key) {
+			return fmt.Sprintf("[%s]", strconv.Quote(s.key))
+		}
+		return s.key
+	}
+}
+
+// IsPattern reports whether xpath selects list elements with a wildcard or a selector, and so
+// must be expanded against a manifest before it can be matched.
+func (xpath XPath) IsPattern() bool {
+	segments, err := xpath.segments()
+	if err != nil {
+		return false
+	}
+	for _, s := range segments {
+		if s.kind == segmentWildcard || s.kind == segmentSelector {
+			return true
+		}
+	}
+	return false
+}
+
+// Split xpath into its segments, e.g. spec.containers[*].image into spec, containers, [*], image.
+// Keys holding a separator or a bracket are quoted in brackets, as in annotations["prometheus.io/scrape"],
+// and so are the values of selectors holding a bracket.
+func (xpath XPath) segments() ([]segment, error) {
+	segments := []segment{}
+	s := string(xpath)
+	for s != "" {
+		if strings.HasPrefix(s, "[") {
+			s = s[1:]
+			var seg segment
+			if strings.HasPrefix(s, `"`) {
+				key, rest, err := unquotePrefix(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid XPath '%s': invalid quoted key", xpath)
+				}
+				seg = segment{kind: segmentKey, key: key}
+				s = rest
+			} else {
+				end := strings.IndexAny(s, XPathSelectorOperator+"]")
+				if end < 0 {
+					return nil, fmt.Errorf("invalid XPath '%s': missing ']'", xpath)
+				}
+				inner := s[:end]
+				s = s[end:]
+				if strings.HasPrefix(s, XPathSelectorOperator) {
+					if inner == "" {
+						return nil, fmt.Errorf("invalid XPath '%s': missing field in selector", xpath)
+					}
+					s = s[len(XPathSelectorOperator):]
+					seg = segment{kind: segmentSelector, key: inner}
+					if strings.HasPrefix(s, `"`) {
+						value, rest, err := unquotePrefix(s)
+						if err != nil {
+							return nil, fmt.Errorf("invalid XPath '%s': invalid quoted value in selector '[%s=...]'", xpath, inner)
+						}
+						seg.value = value
+						s = rest
+					} else if end := strings.Index(s, "]"); end >= 0 {
+						seg.value = s[:end]
+						s = s[end:]
+					}
+				} else if inner == XPathWildcard {
+					seg = segment{kind: segmentWildcard}
+				} else if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+					seg = segment{kind: segmentIndex, index: index}
+				} else {
+					return nil, fmt.Errorf("invalid XPath '%s': invalid index '%s'", xpath, inner)
+				}
+			}
+			if !strings.HasPrefix(s, "]") {
+				return nil, fmt.Errorf("invalid XPath '%s': missing ']'", xpath)
+			}
+			segments = append(segments, seg)
+			s = s[1:]
+			if s != "" && !strings.HasPrefix(s, "[") && !strings.HasPrefix(s, XPathSeparator) {
+				return nil, fmt.Errorf("invalid XPath '%s': missing '%s' after ']'", xpath, XPathSeparator)
+			}
+		} else {
+			end := strings.IndexAny(s, XPathSeparator+"[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid XPath '%s': empty key", xpath)
+			}
+			segments = append(segments, segment{kind: segmentKey, key: s[:end]})
+			s = s[end:]
+		}
+		// A separator is followed by a key, brackets follow keys and brackets directly.
+		if strings.HasPrefix(s, XPathSeparator) {
+			s = s[len(XPathSeparator):]
+			if s == "" || strings.HasPrefix(s, "[") {
+				return nil, fmt.Errorf("invalid XPath '%s': empty key", xpath)
+			}
+		}
+	}
+	return segments, nil
+}
+
+// Unquote the double-quoted string s starts with, and return the rest of s.
+func unquotePrefix(s string) (string, string, error) {
+	quoted, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", "", err
+	}
+	unquoted, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", "", err
+	}
+	return unquoted, s[len(quoted):], nil
+}
+
+// A key must be quoted if it cannot be told apart from the XPath syntax.
+func needsQuote(key string) bool {
+	return key == "" || strings.ContainsAny(key, XPathSeparator+`[]"`)
+}
+
+// Format the segments the way the walk builds XPaths, so that equivalent XPaths compare equal:
+// metadata["labels"] and metadata.labels are the same XPath.
+func (xpath XPath) canonical() XPath {
+	segments, err := xpath.segments()
+	if err != nil {
+		return xpath
+	}
+	canonical := XPath(XPathRoot)
+	for _, s := range segments {
+		if s.kind == segmentKey {
+			canonical = canonical.NewChild(s.key, XPathSliceIndexNone)
+		} else {
+			canonical += XPath(s.String())
+		}
+	}
+	return canonical
+}
+
+// Resolve an XPath written in the legacy form, in which keys holding a separator were not quoted,
+// as in metadata.labels.app.kubernetes.io/name, against the document node. The XPath is returned
+// in its canonical form if it matches no node as is, and does once adjacent keys are joined.
+func resolveLegacy(xpath XPath, node *yamlv3.Node) (XPath, bool) {
+	segments, err := xpath.segments()
+	if err != nil || node == nil {
+		return "", false
+	}
+	if matches(node, segments) {
+		return "", false
+	}
+	return joinKeys(node, segments, XPathRoot)
+}
+
+// Report whether the segments match a node of the document.
+func matches(node *yamlv3.Node, segments []segment) bool {
+	matched := false
+	_ = match(node, segments, XPathRoot, nil, false, func(XPath, []string, *yamlv3.Node) { matched = true })
+	return matched
+}
+
+// Keep the XPaths of config that match a node of the document, as only those are templated.
+func matchingConfig(config Config, node *yamlv3.Node) Config {
+	matching := Config{}
+	for xpath, xpathConfigs := range config {
+		if segments, err := xpath.segments(); err == nil && matches(node, segments) {
+			matching[xpath] = xpathConfigs
+		}
+	}
+	return matching
+}
+
+// Match the segments against node, joining adjacent keys with the separator where the map holds
+// no key for them alone, and return the XPath of the matched node.
+func joinKeys(node *yamlv3.Node, segments []segment, xpath XPath) (XPath, bool) {
+	node = util.ResolveNode(node)
+	if node.Kind == yamlv3.DocumentNode {
+		if len(node.Content) == 0 {
+			return "", false
+		}
+		return joinKeys(node.Content[0], segments, xpath)
+	}
+	if len(segments) == 0 {
+		return xpath, true
+	}
+	s := segments[0]
+	switch {
+	case s.kind == segmentIndex && node.Kind == yamlv3.SequenceNode && s.index < len(node.Content):
+		return joinKeys(node.Content[s.index], segments[1:], xpath.NewElement(s.index))
+	case s.kind == segmentKey && node.Kind == yamlv3.MappingNode:
+		key := ""
+		for i := 0; i < len(segments) && segments[i].kind == segmentKey; i++ {
+			if i > 0 {
+				key += XPathSeparator
+			}
+			key += segments[i].key
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value != key {
+					continue
+				}
+				if resolved, ok := joinKeys(node.Content[j+1], segments[i+1:], xpath.NewChild(key, XPathSliceIndexNone)); ok {
+					return resolved, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Split a values key into the keys of values.yaml, e.g. annotations["prometheus.io/scrape"]
+// into annotations and prometheus.io/scrape.
+func keyPath(key string) []string {
+	segments, err := XPath(key).segments()
+	if err != nil {
+		return strings.Split(key, XPathSeparator)
+	}
+	path := make([]string, len(segments))
+	for i, s := range segments {
+		if s.kind != segmentKey {
+			return strings.Split(key, XPathSeparator)
+		}
+		path[i] = s.key
+	}
+	return path
+}
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Refer to a value of values.yaml. Keys that are not identifiers, such as prometheus.io/scrape,
+// cannot be chained as fields and are looked up with index instead.
+func valuesReference(path []string) string {
+	for _, key := range path {
+		if !identifierRegex.MatchString(key) {
+			quoted := make([]string, len(path))
+			for i, key := range path {
+				quoted[i] = strconv.Quote(key)
+			}
+			return fmt.Sprintf("(index .Values %s)", strings.Join(quoted, " "))
+		}
+	}
+	return ".Values." + strings.Join(path, XPathSeparator)
+}
+
+// ExpandConfig replaces the patterns of config with the XPaths of the elements they match in the
+// document node. Every XPathWildcard of a values key is replaced by the name of the matched element
+// (in lower camel case) if it has one, or by its index otherwise, one wildcard after the other.
+// A selector must match exactly one element of the list it is applied to, otherwise an error is
+// returned along with the rest of the expanded configuration. The configuration of an exact XPath
+// takes precedence over those expanded from patterns. Patterns are left out if node is nil.
+// Exact XPaths in the legacy form, which did not quote keys holding a separator, are resolved
+// against node, and give way to the same XPath written in the canonical form.
+//
+// Patterns are expanded against the whole document before it is walked, rather than resolved along
+// the walk: values.yaml is generated from the same expanded configuration without walking the
+// templates, and exact XPaths must be known to take precedence over the patterns matching them.
+func ExpandConfig(config Config, node *yamlv3.Node) (Config, error) {
+	return expandConfig(config, node, true)
+}
+
+// ExpandSharedConfig expands config as ExpandConfig does, for the configurations shared by several
+// resources, globalConfig and selectorConfig. A selector matching no element means that the rule
+// does not apply to the resource, and only a selector matching several elements is an error.
+func ExpandSharedConfig(config Config, node *yamlv3.Node) (Config, error) {
+	return expandConfig(config, node, false)
+}
+
+func expandConfig(config Config, node *yamlv3.Node, required bool) (Config, error) {
+	expanded := Config{}
+	patterns := []XPath{}
+	legacy := Config{}
+	for xpath, xpathConfigs := range config {
+		if xpath.IsPattern() {
+			patterns = append(patterns, xpath)
+		} else if canonical, ok := resolveLegacy(xpath, node); ok {
+			legacy[canonical] = xpathConfigs
+		} else {
+			expanded[xpath.canonical()] = xpathConfigs
+		}
+	}
+	for xpath, xpathConfigs := range legacy {
+		if _, ok := expanded[xpath]; !ok {
+			expanded[xpath] = xpathConfigs
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i] < patterns[j] })
+
+	var errs []error
+	for _, pattern := range patterns {
+		segments, err := pattern.segments()
+		if err != nil {
+			continue
+		}
+		err = match(node, segments, XPathRoot, nil, required, func(xpath XPath, names []string, _ *yamlv3.Node) {
+			if _, ok := expanded[xpath]; ok {
+				return
+			}
+			xpathConfigs := make(XPathConfigs, len(config[pattern]))
+			for i, xpathConfig := range config[pattern] {
+				xpathConfig.Key = expandKey(xpathConfig.Key, names)
+				xpathConfig.Condition = expandKey(xpathConfig.Condition, names)
+				if len(xpathConfig.Conditions) > 0 {
+					conditions := make([]Condition, len(xpathConfig.Conditions))
+					for j, condition := range xpathConfig.Conditions {
+						condition.Key = expandKey(condition.Key, names)
+						conditions[j] = condition
+					}
+					xpathConfig.Conditions = conditions
+				}
+				xpathConfigs[i] = xpathConfig
+			}
+			expanded[xpath] = xpathConfigs
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("XPath '%s': %w", pattern, err))
+		}
+	}
+	return expanded, errors.Join(errs...)
+}
+
+// Visit the XPath of every node matched by segments, along with the names of the elements
+// matched by wildcards and the node itself. A selector matching several elements is an error,
+// and so is a selector matching no element if required is set.
+func match(node *yamlv3.Node, segments []segment, xpath XPath, names []string, required bool, visit func(XPath, []string, *yamlv3.Node)) error {
+	if node == nil {
+		return nil
+	}
+	node = util.ResolveNode(node)
+	if node.Kind == yamlv3.DocumentNode {
+		if len(node.Content) > 0 {
+			return match(node.Content[0], segments, xpath, names, required, visit)
+		}
+		return nil
+	}
+	if len(segments) == 0 {
+		visit(xpath, names, node)
+		return nil
+	}
+
+	s, rest := segments[0], segments[1:]
+	switch s.kind {
+	case segmentKey:
+		if node.Kind != yamlv3.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == s.key {
+				return match(node.Content[i+1], rest, xpath.NewChild(s.key, XPathSliceIndexNone), names, required, visit)
+			}
+		}
+	case segmentIndex:
+		if node.Kind == yamlv3.SequenceNode && s.index < len(node.Content) {
+			return match(node.Content[s.index], rest, xpath.NewElement(s.index), names, required, visit)
+		}
+	case segmentWildcard:
+		if node.Kind != yamlv3.SequenceNode {
+			return nil
+		}
+		var errs []error
+		for i, element := range node.Content {
+			errs = append(errs, match(element, rest, xpath.NewElement(i), append(names[:len(names):len(names)], elementName(element, i)), required, visit))
+		}
+		return errors.Join(errs...)
+	case segmentSelector:
+		if node.Kind != yamlv3.SequenceNode {
+			return nil
+		}
+		matches := []int{}
+		for i, element := range node.Content {
+			if value, ok := field(element, s.key); ok && value == s.value {
+				matches = append(matches, i)
+			}
+		}
+		if len(matches) == 0 && !required {
+			return nil
+		}
+		if len(matches) != 1 {
+			return fmt.Errorf("selector '%s' at '%s' matches %d elements, expected exactly one", s, xpath, len(matches))
+		}
+		return match(node.Content[matches[0]], rest, xpath.NewElement(matches[0]), names, required, visit)
+	}
+	return nil
+}
+
+// Return the scalar value of a field of a map node.
+func field(node *yamlv3.Node, key string) (string, bool) {
+	node = util.ResolveNode(node)
+	if node.Kind != yamlv3.MappingNode {
+		return "", false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			if value := util.ResolveNode(node.Content[i+1]); value.Kind == yamlv3.ScalarNode {
+				return value.Value, true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// The name of a list element is its name field, such as the name of a container or a port.
+func elementName(element *yamlv3.Node, index int) string {
+	if name, ok := field(element, "name"); ok {
+		return strcase.ToLowerCamel(name)
+	}
+	return strconv.Itoa(index)
+}
+
+func expandKey(key string, names []string) string {
+	for _, name := range names {
+		key = strings.Replace(key, XPathWildcard, name, 1)
+	}
+	return key
+}
+
+func hasPatterns(config Config) bool {
+	for xpath := range config {
+		if xpath.IsPattern() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const deploymentWithContainers = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  template:
+    spec:
+      containers:
+      - name: kube-rbac-proxy
+        image: kube-rbac-proxy:v0.13.0
+        ports:
+        - containerPort: 8443
+      - name: manager
+        image: controller:latest
+        ports:
+        - containerPort: 8080
+          protocol: TCP
+        - containerPort: 8081
+          protocol: TCP
+        env:
+        - name: LOG_LEVEL
+          value: info
+        - name: WATCH_NAMESPACE
+          value: ""
+`
+
+func TestXPathSegments(t *testing.T) {
+	segments, err := XPath("spec.containers[*].ports[1].containerPort").segments()
+	require.NoError(t, err)
+	require.Equal(t, []segment{
+		{kind: segmentKey, key: "spec"},
+		{kind: segmentKey, key: "containers"},
+		{kind: segmentWildcard},
+		{kind: segmentKey, key: "ports"},
+		{kind: segmentIndex, index: 1},
+		{kind: segmentKey, key: "containerPort"},
+	}, segments)
+
+	segments, err = XPath("containers[name=manager].env[name=LOG_LEVEL].value").segments()
+	require.NoError(t, err)
+	require.Equal(t, []segment{
+		{kind: segmentKey, key: "containers"},
+		{kind: segmentSelector, key: "name", value: "manager"},
+		{kind: segmentKey, key: "env"},
+		{kind: segmentSelector, key: "name", value: "LOG_LEVEL"},
+		{kind: segmentKey, key: "value"},
+	}, segments)
+	require.True(t, XPath("containers[name=manager].image").IsPattern())
+	require.False(t, XPath("containers[0].image").IsPattern())
+
+	for _, xpath := range []XPath{"spec.", "spec..replicas", "containers[0", "containers[x]", "containers[-1]", "containers[=manager]"} {
+		_, err := xpath.segments()
+		require.Error(t, err, xpath)
+	}
+}
+
+func TestExpandConfig(t *testing.T) {
+	node := &yamlv3.Node{}
+	require.NoError(t, yamlv3.Unmarshal([]byte(deploymentWithContainers), node))
+
+	config := Config{
+		"spec.template.spec.containers[*].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "*.image"},
+		},
+		"spec.template.spec.containers[1].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "image"},
+		},
+		"spec.template.spec.containers[*].ports[*].containerPort": []XPathConfig{
+			{Strategy: XPathStrategyControlIf, Key: "*.port*", Conditions: []Condition{{Key: "*.enabled"}}},
+		},
+		"spec.template.spec.volumes[*].name": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "*.volume"},
+		},
+	}
+	expanded, err := ExpandConfig(config, node)
+	require.NoError(t, err)
+
+	require.Len(t, expanded, 5)
+	require.Equal(t, "kubeRbacProxy.image", expanded["spec.template.spec.containers[0].image"][0].Key)
+	// An exact XPath takes precedence over a pattern.
+	require.Equal(t, "image", expanded["spec.template.spec.containers[1].image"][0].Key)
+	require.Equal(t, "kubeRbacProxy.port0", expanded["spec.template.spec.containers[0].ports[0].containerPort"][0].Key)
+	require.Equal(t, "manager.port1", expanded["spec.template.spec.containers[1].ports[1].containerPort"][0].Key)
+	require.Equal(t, "manager.enabled", expanded["spec.template.spec.containers[1].ports[1].containerPort"][0].Conditions[0].Key)
+	// The pattern itself is left untouched.
+	require.Equal(t, "*.port*", config["spec.template.spec.containers[*].ports[*].containerPort"][0].Key)
+
+	expanded, err = ExpandConfig(config, nil)
+	require.NoError(t, err)
+	require.Len(t, expanded, 1)
+}
+
+func TestExpandConfigLegacyKeys(t *testing.T) {
+	node := &yamlv3.Node{}
+	require.NoError(t, yamlv3.Unmarshal([]byte(`metadata:
+  labels:
+    app.kubernetes.io/name: nginx
+    app.kubernetes.io/part-of: web
+    tier: frontend
+`), node))
+
+	expanded, err := ExpandConfig(Config{
+		"metadata.labels.app.kubernetes.io/name": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "name"},
+		},
+		"metadata.labels.app.kubernetes.io/part-of": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "legacy"},
+		},
+		`metadata.labels["app.kubernetes.io/part-of"]`: []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "partOf"},
+		},
+		"metadata.labels.tier": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "tier"},
+		},
+	}, node)
+	require.NoError(t, err)
+	require.Len(t, expanded, 3)
+	require.Equal(t, "name", expanded[`metadata.labels["app.kubernetes.io/name"]`][0].Key)
+	// The canonical form takes precedence over the legacy one.
+	require.Equal(t, "partOf", expanded[`metadata.labels["app.kubernetes.io/part-of"]`][0].Key)
+	require.Equal(t, "tier", expanded["metadata.labels.tier"][0].Key)
+}
+
+func TestValuesExpandsPatterns(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nginx-deployment.yaml"), []byte(deploymentWithContainers), 0644))
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig["apps/v1/Deployment//nginx"] = Config{
+		"spec.template.spec.containers[*].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "*.image", Value: "nginx"},
+		},
+	}
+	require.NoError(t, config.IndexManifests(dir))
+
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "nginxDeployment:\n  kubeRbacProxy:\n    image: nginx\n  manager:\n    image: nginx\n")
+	require.NotContains(t, values, "'*'")
+}
+
+func TestExpandConfigSelectors(t *testing.T) {
+	node := &yamlv3.Node{}
+	require.NoError(t, yamlv3.Unmarshal([]byte(deploymentWithContainers), node))
+
+	expanded, err := ExpandConfig(Config{
+		"spec.template.spec.containers[name=manager].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "manager.image"},
+		},
+		"spec.template.spec.containers[name=manager].env[name=LOG_LEVEL].value": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "logLevel"},
+		},
+		// Resources without the list are not an error.
+		"spec.template.spec.initContainers[name=init].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "init.image"},
+		},
+	}, node)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		"spec.template.spec.containers[1].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "manager.image"},
+		},
+		"spec.template.spec.containers[1].env[0].value": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "logLevel"},
+		},
+	}, expanded)
+
+	_, err = ExpandConfig(Config{
+		"spec.template.spec.containers[name=proxy].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "proxy.image"},
+		},
+		"spec.template.spec.containers[*].ports[containerPort=8443].containerPort": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "*.port"},
+		},
+	}, node)
+	require.ErrorContains(t, err, "XPath 'spec.template.spec.containers[name=proxy].image': selector '[name=proxy]' at 'spec.template.spec.containers' matches 0 elements, expected exactly one")
+	// Every container matched by the wildcard must have the port.
+	require.ErrorContains(t, err, "selector '[containerPort=8443]' at 'spec.template.spec.containers[1].ports' matches 0 elements")
+	require.NotContains(t, err.Error(), "containers[0].ports")
+
+	_, err = ExpandConfig(Config{
+		"spec.template.spec.containers[image=controller:latest].ports[protocol=TCP].containerPort": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "port"},
+		},
+	}, node)
+	require.ErrorContains(t, err, "selector '[protocol=TCP]' at 'spec.template.spec.containers[1].ports' matches 2 elements, expected exactly one")
+
+	// Shared configurations do not apply to resources without the selected element.
+	expanded, err = ExpandSharedConfig(Config{
+		"spec.template.spec.containers[name=proxy].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "proxy.image"},
+		},
+		"spec.template.spec.containers[name=manager].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "manager.image"},
+		},
+	}, node)
+	require.NoError(t, err)
+	require.Equal(t, Config{
+		"spec.template.spec.containers[1].image": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "manager.image"},
+		},
+	}, expanded)
+	_, err = ExpandSharedConfig(Config{
+		"spec.template.spec.containers[image=controller:latest].ports[protocol=TCP].containerPort": []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: "port"},
+		},
+	}, node)
+	require.ErrorContains(t, err, "matches 2 elements, expected exactly one")
+}
+
+func TestXPathEscapedKeys(t *testing.T) {
+	xpath := XPath("metadata.annotations").NewChild("prometheus.io/scrape", XPathSliceIndexNone)
+	require.Equal(t, XPath(`metadata.annotations["prometheus.io/scrape"]`), xpath)
+	require.Equal(t, XPath(`spec.containers[0]["a[b]"]`), XPath("spec.containers").NewChild("a[b]", 0))
+	require.Equal(t, XPath(`["x.y"]`), XPath(XPathRoot).NewChild("x.y", XPathSliceIndexNone))
+	require.Equal(t, XPath("spec.containers[0].name"), XPath("spec.containers").NewChild("name", 0))
+
+	segments, err := XPath(`metadata.annotations["prometheus.io/scrape"].x[name="a]b"]`).segments()
+	require.NoError(t, err)
+	require.Equal(t, []segment{
+		{kind: segmentKey, key: "metadata"},
+		{kind: segmentKey, key: "annotations"},
+		{kind: segmentKey, key: "prometheus.io/scrape"},
+		{kind: segmentKey, key: "x"},
+		{kind: segmentSelector, key: "name", value: "a]b"},
+	}, segments)
+
+	require.Equal(t, XPath(`metadata.labels["app.kubernetes.io/name"]`), XPath(`metadata["labels"]["app.kubernetes.io/name"]`).canonical())
+	require.Equal(t, XPath(`containers[name="a]b"].image`), XPath(`containers[name="a]b"]["image"]`).canonical())
+
+	for _, xpath := range []XPath{`metadata["labels`, `metadata["labels"`, `metadata["labels"x]`, `metadata["labels"].["app.kubernetes.io/name"]`, `metadata.["labels"]`, `containers[0].`, `containers[0]name`} {
+		_, err := xpath.segments()
+		require.Error(t, err, xpath)
+	}
+}
+
+func TestValuesKeys(t *testing.T) {
+	require.Equal(t, []string{"annotations", "prometheus.io/scrape"}, keyPath(`annotations["prometheus.io/scrape"]`))
+	require.Equal(t, []string{"manager", "image"}, keyPath("manager.image"))
+
+	require.Equal(t, ".Values.nginx.manager.image", valuesReference([]string{"nginx", "manager", "image"}))
+	require.Equal(t, `(index .Values "nginx" "annotations" "prometheus.io/scrape")`, valuesReference([]string{"nginx", "annotations", "prometheus.io/scrape"}))
+	require.Equal(t, `(index .Values "nginx" "0" "image")`, valuesReference([]string{"nginx", "0", "image"}))
+
+	config := NewChartConfig(zap.New(), "mychart")
+	config.FileConfig["nginx.yaml"] = Config{
+		`metadata.annotations["prometheus.io/scrape"]`: []XPathConfig{
+			{Strategy: XPathStrategyInline, Key: `annotations["prometheus.io/scrape"]`, Value: "true"},
+		},
+	}
+	values, err := config.Values()
+	require.NoError(t, err)
+	require.Contains(t, values, "nginx:\n  annotations:\n    prometheus.io/scrape: \"true\"\n")
+	key, _, _ := config.GetFormattedKeyWithDefaultValue(&config.FileConfig["nginx.yaml"][`metadata.annotations["prometheus.io/scrape"]`][0], "nginx")
+	require.Equal(t, `(index .Values "nginx" "annotations" "prometheus.io/scrape")`, key)
+}
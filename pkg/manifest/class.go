@@ -0,0 +1,48 @@
+package manifest
+
+import "strings"
+
+// Class groups resources that need special handling when generating a chart.
+type Class string
+
+const (
+	ClassGeneric                  Class = "generic"
+	ClassCustomResourceDefinition Class = "crd"
+	ClassNamespace                Class = "namespace"
+	ClassWebhookConfiguration     Class = "webhook"
+	ClassAPIService               Class = "apiservice"
+)
+
+type groupKind struct {
+	group string
+	kind  string
+}
+
+var classes = map[groupKind]Class{
+	{"apiextensions.k8s.io", "CustomResourceDefinition"}: ClassCustomResourceDefinition,
+	{"", "Namespace"}: ClassNamespace,
+	{"admissionregistration.k8s.io", "MutatingWebhookConfiguration"}:   ClassWebhookConfiguration,
+	{"admissionregistration.k8s.io", "ValidatingWebhookConfiguration"}: ClassWebhookConfiguration,
+	{"apiregistration.k8s.io", "APIService"}:                           ClassAPIService,
+}
+
+// Group returns the API group of apiVersion, which is empty for the core group.
+func Group(apiVersion string) string {
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		return apiVersion[:i]
+	}
+	return ""
+}
+
+// Classify determines the class of a resource from its apiVersion and kind.
+func Classify(apiVersion, kind string) Class {
+	if class, ok := classes[groupKind{Group(apiVersion), kind}]; ok {
+		return class
+	}
+	return ClassGeneric
+}
+
+// Class returns the class of the resource.
+func (r *Resource) Class() Class {
+	return Classify(r.APIVersion, r.Kind)
+}
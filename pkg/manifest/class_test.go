@@ -0,0 +1,30 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		kind       string
+		expected   Class
+	}{
+		{"apiextensions.k8s.io/v1", "CustomResourceDefinition", ClassCustomResourceDefinition},
+		{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", ClassCustomResourceDefinition},
+		{"v1", "Namespace", ClassNamespace},
+		{"admissionregistration.k8s.io/v1", "MutatingWebhookConfiguration", ClassWebhookConfiguration},
+		{"admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration", ClassWebhookConfiguration},
+		{"apiregistration.k8s.io/v1", "APIService", ClassAPIService},
+		{"apps/v1", "Deployment", ClassGeneric},
+		{"example.com/v1", "Namespace", ClassGeneric},
+	}
+
+	for _, test := range tests {
+		t.Run(test.apiVersion+"/"+test.kind, func(t *testing.T) {
+			require.Equal(t, test.expected, Classify(test.apiVersion, test.kind))
+		})
+	}
+}
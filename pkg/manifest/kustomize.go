@@ -0,0 +1,31 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Kustomize runs the equivalent of `kustomize build dir` in-process and splits the result into resources.
+func Kustomize(dir string) ([]*Resource, error) {
+	k := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	m, err := k.Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, fmt.Errorf("error building kustomization '%s': %w", dir, err)
+	}
+	out, err := m.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("error rendering kustomization '%s': %w", dir, err)
+	}
+
+	resources, err := Split(bytes.NewReader(out), dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDuplicates(resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
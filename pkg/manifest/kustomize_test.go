@@ -0,0 +1,43 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKustomize(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"base/kustomization.yaml": "resources:\n- deployment.yaml\n",
+		"base/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+        image: controller:latest
+`,
+		"default/kustomization.yaml": `namespace: system
+namePrefix: memcached-
+resources:
+- ../base
+images:
+- name: controller
+  newName: example.com/memcached
+  newTag: v0.1.0
+`,
+	})
+
+	resources, err := Kustomize(dir + "/default")
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	require.Equal(t, "apps/v1/Deployment/system/memcached-manager", resources[0].Identity())
+	require.Contains(t, string(resources[0].Raw), "image: example.com/memcached:v0.1.0")
+
+	_, err = Kustomize(dir + "/missing")
+	require.Error(t, err)
+}
@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// FileExt is the extension of every intermediate manifest file.
+	FileExt = ".yaml"
+
+	// DefaultNamingTemplate names files the same way as kubernetes-split-yaml, e.g. nginx-deployment.yaml.
+	DefaultNamingTemplate = "{{ .name }}-{{ .kind | short }}"
+)
+
+// XXX: Keep in sync with kubernetes-split-yaml so that existing config files continue to match.
+var shortKinds = map[string]string{
+	"service":                  "svc",
+	"serviceaccount":           "sa",
+	"rolebinding":              "rb",
+	"clusterrolebinding":       "crb",
+	"clusterrole":              "cr",
+	"horizontalpodautoscaler":  "hpa",
+	"poddisruptionbudget":      "pdb",
+	"customresourcedefinition": "crd",
+	"configmap":                "cm",
+}
+
+// ShortKind returns the abbreviated, lower case form of kind.
+func ShortKind(kind string) string {
+	kind = strings.ToLower(kind)
+	if short, ok := shortKinds[kind]; ok {
+		return short
+	}
+	return kind
+}
+
+var namingFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"short": ShortKind,
+}
+
+// Namer derives intermediate file names from resources using a text/template.
+// The template can refer to .apiVersion, .kind, .name and .namespace, and use the lower, upper and short functions.
+type Namer struct {
+	tmpl *template.Template
+}
+
+// NewNamer parses the naming template, falling back to DefaultNamingTemplate if text is empty.
+func NewNamer(text string) (*Namer, error) {
+	if text == "" {
+		text = DefaultNamingTemplate
+	}
+	tmpl, err := template.New("naming").Funcs(namingFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naming template '%s': %w", text, err)
+	}
+	return &Namer{tmpl: tmpl}, nil
+}
+
+// Name returns the file name of a single resource, without taking other resources into account.
+func (n *Namer) Name(r *Resource) (string, error) {
+	var buf bytes.Buffer
+	err := n.tmpl.Execute(&buf, map[string]string{
+		"apiVersion": r.APIVersion,
+		"kind":       r.Kind,
+		"name":       r.Name,
+		"namespace":  r.Namespace,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: error naming %s '%s': %w", r.Location(), r.Kind, r.Name, err)
+	}
+	name := strings.TrimSpace(buf.String())
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("%s: %s '%s' is named '%s', which cannot be used as a file name", r.Location(), r.Kind, r.Name, name)
+	}
+	return name + FileExt, nil
+}
+
+// Names returns a unique file name for each resource, in the same order.
+// Resources mapping to the same name are sorted by identity; the first one keeps the name
+// and the others get a numeric suffix, so the result does not depend on the input order.
+func (n *Namer) Names(resources []*Resource) ([]string, error) {
+	names := make([]string, len(resources))
+	groups := map[string][]int{}
+	for i, r := range resources {
+		name, err := n.Name(r)
+		if err != nil {
+			return nil, err
+		}
+		names[i] = name
+		groups[name] = append(groups[name], i)
+	}
+
+	taken := make(map[string]bool, len(names))
+	for name := range groups {
+		taken[name] = true
+	}
+
+	collisions := []string{}
+	for name, group := range groups {
+		if len(group) > 1 {
+			collisions = append(collisions, name)
+		}
+	}
+	sort.Strings(collisions)
+
+	for _, name := range collisions {
+		group := groups[name]
+		sort.Slice(group, func(i, j int) bool {
+			return resources[group[i]].Identity() < resources[group[j]].Identity()
+		})
+		base := strings.TrimSuffix(name, FileExt)
+		suffix := 2
+		for _, i := range group[1:] {
+			for {
+				candidate := fmt.Sprintf("%s-%d%s", base, suffix, FileExt)
+				suffix++
+				if !taken[candidate] {
+					taken[candidate] = true
+					names[i] = candidate
+					break
+				}
+			}
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,63 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultNaming(t *testing.T) {
+	tests := map[string]*Resource{
+		"nginx-deployment.yaml":           {Kind: "Deployment", Name: "nginx"},
+		"xyz-sa.yaml":                     {Kind: "ServiceAccount", Name: "xyz"},
+		"my-clusterrolebinding-crb.yaml":  {Kind: "ClusterRoleBinding", Name: "my-clusterrolebinding"},
+		"my-namespace-namespace.yaml":     {Kind: "Namespace", Name: "my-namespace"},
+		"memcacheds.example.com-crd.yaml": {Kind: "CustomResourceDefinition", Name: "memcacheds.example.com"},
+	}
+
+	namer, err := NewNamer("")
+	require.NoError(t, err)
+	for expected, resource := range tests {
+		name, err := namer.Name(resource)
+		require.NoError(t, err)
+		require.Equal(t, expected, name)
+	}
+}
+
+func TestNamingTemplate(t *testing.T) {
+	namer, err := NewNamer("{{ .kind | lower }}-{{ .name }}")
+	require.NoError(t, err)
+	name, err := namer.Name(&Resource{Kind: "Deployment", Name: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "deployment-nginx.yaml", name)
+
+	namer, err = NewNamer("{{ .namespace }}/{{ .name }}")
+	require.NoError(t, err)
+	_, err = namer.Name(&Resource{Kind: "Deployment", Name: "nginx", Namespace: "default"})
+	require.Error(t, err)
+
+	_, err = NewNamer("{{ .name ")
+	require.Error(t, err)
+}
+
+func TestNamesDisambiguatesCollisions(t *testing.T) {
+	namer, err := NewNamer("{{ .kind | lower }}-{{ .name }}")
+	require.NoError(t, err)
+
+	resources := []*Resource{
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role", Name: "reader", Namespace: "b"},
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "config"},
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role", Name: "reader-2"},
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role", Name: "reader", Namespace: "a"},
+		{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role", Name: "reader", Namespace: "c"},
+	}
+	names, err := namer.Names(resources)
+	require.NoError(t, err)
+	require.Equal(t, []string{"role-reader-3.yaml", "configmap-config.yaml", "role-reader-2.yaml", "role-reader.yaml", "role-reader-4.yaml"}, names)
+
+	// Reversing the input does not change the names.
+	reversed := []*Resource{resources[4], resources[3], resources[2], resources[1], resources[0]}
+	names, err = namer.Names(reversed)
+	require.NoError(t, err)
+	require.Equal(t, []string{"role-reader-4.yaml", "role-reader.yaml", "role-reader-2.yaml", "configmap-config.yaml", "role-reader-3.yaml"}, names)
+}
@@ -0,0 +1,38 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resource is a single Kubernetes object split out of a manifest stream.
+type Resource struct {
+	APIVersion string
+	Kind       string
+	Name       string
+	Namespace  string
+	Labels     map[string]string
+
+	// Source is the file (or stream) the resource was read from.
+	Source string
+	// Index is the zero-based position of the document within Source.
+	Index int
+	// Item is the zero-based position within the List document at Index, only set when FromList is true.
+	Item     int
+	FromList bool
+	// Raw is the original document content, including comments.
+	Raw []byte
+}
+
+// Identity returns apiVersion/kind/namespace/name, which uniquely identifies the resource.
+func (r *Resource) Identity() string {
+	return strings.Join([]string{r.APIVersion, r.Kind, r.Namespace, r.Name}, "/")
+}
+
+// Location describes where the resource came from, for use in error messages.
+func (r *Resource) Location() string {
+	if r.FromList {
+		return fmt.Sprintf("item %d of document %d in %s", r.Item, r.Index, r.Source)
+	}
+	return fmt.Sprintf("document %d in %s", r.Index, r.Source)
+}
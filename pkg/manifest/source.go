@@ -0,0 +1,161 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/api/konfig"
+)
+
+const (
+	// Stdin is the source name that reads the manifest from standard input.
+	Stdin = "-"
+
+	stdinName = "stdin"
+)
+
+// Extensions of the files picked up when a source is a directory.
+var manifestExts = map[string]bool{
+	".yaml": true,
+	".yml":  true,
+	".json": true,
+}
+
+// Expand resolves files, directories (recursively) and glob patterns into a sorted, de-duplicated list of files.
+// Kustomization files are left out of directories.
+// Stdin is passed through as-is.
+func Expand(sources []string) ([]string, error) {
+	expanded := []string{}
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			expanded = append(expanded, path)
+		}
+	}
+
+	for _, source := range sources {
+		if source == Stdin {
+			add(source)
+			continue
+		}
+
+		matches := []string{source}
+		if isGlob(source) {
+			var err error
+			matches, err = filepath.Glob(source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern '%s': %w", source, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("pattern '%s' does not match any file", source)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			files, err := walkDir(match)
+			if err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("directory '%s' does not contain any manifest", match)
+			}
+			for _, file := range files {
+				add(file)
+			}
+		}
+	}
+
+	return expanded, nil
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Kustomization files, which directories such as config/rbac of an operator hold along with their
+// manifests, are not Kubernetes objects.
+func isKustomization(path string) bool {
+	return slices.Contains(konfig.RecognizedKustomizationFileNames(), filepath.Base(path))
+}
+
+func walkDir(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && manifestExts[strings.ToLower(filepath.Ext(path))] && !isKustomization(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// ReadAll splits every source into resources and merges them into one set.
+// Resources sharing the same identity are reported as errors.
+func ReadAll(sources []string, stdin io.Reader) ([]*Resource, error) {
+	resources := []*Resource{}
+	var errs []error
+	for _, source := range sources {
+		rs, err := read(source, stdin)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resources = append(resources, rs...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := checkDuplicates(resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+func read(source string, stdin io.Reader) ([]*Resource, error) {
+	if source == Stdin {
+		return Split(stdin, stdinName)
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Split(f, source)
+}
+
+func checkDuplicates(resources []*Resource) error {
+	owners := make(map[string]*Resource, len(resources))
+	var errs []error
+	for _, resource := range resources {
+		identity := resource.Identity()
+		if owner, ok := owners[identity]; ok {
+			errs = append(errs, fmt.Errorf("%s: duplicate resource '%s', already defined in %s", resource.Location(), identity, owner.Location()))
+			continue
+		}
+		owners[identity] = resource
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,93 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"crd/bases/memcached.yaml": "",
+		"rbac/role.yml":            "",
+		"rbac/binding.json":        "",
+		"rbac/README.md":           "",
+		"rbac/kustomization.yaml":  "resources:\n- role.yml\n",
+		"manager.yaml":             "",
+	})
+
+	sources, err := Expand([]string{
+		filepath.Join(dir, "*.yaml"),
+		filepath.Join(dir, "crd"),
+		filepath.Join(dir, "rbac"),
+		filepath.Join(dir, "manager.yaml"),
+		Stdin,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "manager.yaml"),
+		filepath.Join(dir, "crd/bases/memcached.yaml"),
+		filepath.Join(dir, "rbac/binding.json"),
+		filepath.Join(dir, "rbac/role.yml"),
+		Stdin,
+	}, sources)
+
+	_, err = Expand([]string{filepath.Join(dir, "*.json")})
+	require.Error(t, err)
+	_, err = Expand([]string{filepath.Join(dir, "missing.yaml")})
+	require.Error(t, err)
+}
+
+func TestReadAllReportsDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	role := `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: reader
+  namespace: default
+`
+	writeFiles(t, dir, map[string]string{
+		"a.yaml": role,
+		"b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n---\n" + role,
+	})
+
+	_, err := ReadAll([]string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "document 1 in "+filepath.Join(dir, "b.yaml")+": duplicate resource 'rbac.authorization.k8s.io/v1/Role/default/reader', already defined in document 0 in "+filepath.Join(dir, "a.yaml"))
+
+	resources, err := ReadAll([]string{filepath.Join(dir, "b.yaml"), Stdin}, strings.NewReader("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: system\n"))
+	require.NoError(t, err)
+	require.Len(t, resources, 3)
+	require.Equal(t, "stdin", resources[2].Source)
+}
+
+func TestReadAllSkipsKustomizations(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"rbac/role.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: reader
+`,
+		"rbac/kustomization.yaml": "resources:\n- role.yaml\n",
+	})
+
+	sources, err := Expand([]string{filepath.Join(dir, "rbac")})
+	require.NoError(t, err)
+	resources, err := ReadAll(sources, nil)
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	require.Equal(t, "Role", resources[0].Kind)
+}
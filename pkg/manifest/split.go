@@ -0,0 +1,286 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	documentSeparator = "---"
+)
+
+// header is the minimal set of fields needed to identify and select a Kubernetes object.
+type header struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace"`
+		Labels    map[string]string `yaml:"labels"`
+	} `yaml:"metadata"`
+}
+
+// Split reads a multi-document YAML stream and returns one resource per non-empty document.
+// JSON objects, JSON arrays and JSON-lines streams are accepted as well, and converted to YAML.
+// Every document that cannot be parsed or identified is reported, not just the first one.
+func Split(r io.Reader, source string) ([]*Resource, error) {
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", source, err)
+	}
+	if isJSON(bs) {
+		return splitJSON(bs, source)
+	}
+
+	documents, err := splitDocuments(bytes.NewReader(bs))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", source, err)
+	}
+
+	resources := make([]*Resource, 0, len(documents))
+	var errs []error
+	for i, document := range documents {
+		if isEmptyDocument(document) {
+			continue
+		}
+		rs, err := parseResources(document, source, i)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		resources = append(resources, rs...)
+	}
+
+	return resources, errors.Join(errs...)
+}
+
+// A JSON stream starts with an object or an array, which a YAML manifest hardly ever does.
+func isJSON(bs []byte) bool {
+	bs = bytes.TrimSpace(bs)
+	return len(bs) > 0 && (bs[0] == '{' || bs[0] == '[')
+}
+
+// Split a stream of JSON values. Each value is a document, and the elements of an array are
+// handled like the items of a List.
+func splitJSON(bs []byte, source string) ([]*Resource, error) {
+	resources := []*Resource{}
+	var errs []error
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	for index := 0; ; index++ {
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			location := (&Resource{Source: source, Index: index}).Location()
+			errs = append(errs, fmt.Errorf("%s: invalid JSON: %w", location, err))
+			break
+		}
+
+		rs, err := parseJSON(raw, source, index)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		resources = append(resources, rs...)
+	}
+
+	return resources, errors.Join(errs...)
+}
+
+// JSON is valid YAML, so the value is converted by re-encoding it with the YAML encoder,
+// which keeps the order of the keys.
+func parseJSON(raw json.RawMessage, source string, index int) ([]*Resource, error) {
+	root := yamlv3.Node{}
+	if err := yamlv3.Unmarshal(raw, &root); err != nil {
+		location := (&Resource{Source: source, Index: index}).Location()
+		return nil, fmt.Errorf("%s: invalid JSON: %w", location, err)
+	}
+	value := root.Content[0]
+	resetStyle(value)
+	if value.Kind == yamlv3.SequenceNode {
+		return flattenList(value.Content, source, index)
+	}
+
+	document, err := encode(value)
+	if err != nil {
+		location := (&Resource{Source: source, Index: index}).Location()
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	return parseResources(document, source, index)
+}
+
+// Drop the flow and quoting styles of JSON so that the node is written as block YAML.
+func resetStyle(node *yamlv3.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// Encode a node the same way as the manifests written by the splitter.
+func encode(node *yamlv3.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func splitDocuments(r io.Reader) ([][]byte, error) {
+	documents := [][]byte{}
+	var current bytes.Buffer
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if isDocumentSeparator(line) {
+			documents = append(documents, bytes.Clone(current.Bytes()))
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+		current.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	documents = append(documents, current.Bytes())
+
+	return documents, nil
+}
+
+func isDocumentSeparator(line string) bool {
+	if !strings.HasPrefix(line, documentSeparator) {
+		return false
+	}
+	rest := strings.TrimPrefix(line, documentSeparator)
+	return rest == "" || rest[0] == ' ' || rest[0] == '\t'
+}
+
+// A document holding nothing but comments and blank lines does not describe a resource.
+func isEmptyDocument(document []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(document))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse a document into its resource, or into its members if the document is a List.
+func parseResources(document []byte, source string, index int) ([]*Resource, error) {
+	resource := &Resource{
+		Source: source,
+		Index:  index,
+		Raw:    append(bytes.TrimSpace(document), '\n'),
+	}
+
+	h := header{}
+	if err := yaml.Unmarshal(document, &h); err != nil {
+		return nil, fmt.Errorf("%s: invalid YAML: %w", resource.Location(), err)
+	}
+	if isList(h.Kind) {
+		items, ok, err := listItems(document)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %w", resource.Location(), h.Kind, err)
+		}
+		if ok {
+			return flattenList(items, source, index)
+		}
+	}
+	if err := resource.identify(h); err != nil {
+		return nil, err
+	}
+
+	return []*Resource{resource}, nil
+}
+
+func (r *Resource) identify(h header) error {
+	if h.Kind == "" {
+		return fmt.Errorf("%s: missing 'kind'", r.Location())
+	}
+	if h.Metadata.Name == "" {
+		return fmt.Errorf("%s: %s is missing 'metadata.name'", r.Location(), h.Kind)
+	}
+	r.APIVersion = h.APIVersion
+	r.Kind = h.Kind
+	r.Name = h.Metadata.Name
+	r.Namespace = h.Metadata.Namespace
+	r.Labels = h.Metadata.Labels
+
+	return nil
+}
+
+// Both `kind: List` and typed lists such as `kind: DeploymentList` hold their members in `items`.
+func isList(kind string) bool {
+	return strings.HasSuffix(kind, "List")
+}
+
+// Return the items of a List document. ok is false if the document has no `items` sequence.
+func listItems(document []byte) (items []*yamlv3.Node, ok bool, err error) {
+	root := yamlv3.Node{}
+	if err := yamlv3.Unmarshal(document, &root); err != nil {
+		return nil, false, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yamlv3.MappingNode {
+		return nil, false, nil
+	}
+	mapping := root.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != "items" {
+			continue
+		}
+		value := mapping.Content[i+1]
+		if value.Kind != yamlv3.SequenceNode {
+			return nil, false, fmt.Errorf("'items' must be a list")
+		}
+		return value.Content, true, nil
+	}
+	return nil, false, nil
+}
+
+func flattenList(items []*yamlv3.Node, source string, index int) ([]*Resource, error) {
+	resources := make([]*Resource, 0, len(items))
+	var errs []error
+	for i, item := range items {
+		resource := &Resource{
+			Source:   source,
+			Index:    index,
+			Item:     i,
+			FromList: true,
+		}
+
+		raw, err := encode(item)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", resource.Location(), err))
+			continue
+		}
+		resource.Raw = raw
+
+		h := header{}
+		if err := yaml.Unmarshal(resource.Raw, &h); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid YAML: %w", resource.Location(), err))
+			continue
+		}
+		if err := resource.identify(h); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	return resources, errors.Join(errs...)
+}
@@ -0,0 +1,137 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	input := `---
+# Source: yourchart/templates/serviceaccount.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: xyz
+  namespace: default
+---
+---  
+# Only a comment
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  template:
+    spec:
+      containers:
+      - args:
+        - "---"
+`
+	resources, err := Split(strings.NewReader(input), "input.yaml")
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	require.Equal(t, "v1/ServiceAccount/default/xyz", resources[0].Identity())
+	require.True(t, strings.HasPrefix(string(resources[0].Raw), "# Source: yourchart/templates/serviceaccount.yaml\n"))
+	require.Equal(t, "apps/v1/Deployment//nginx", resources[1].Identity())
+	require.Equal(t, 4, resources[1].Index)
+	require.True(t, strings.HasSuffix(string(resources[1].Raw), "- \"---\"\n"))
+}
+
+func TestSplitReportsEveryInvalidDocument(t *testing.T) {
+	input := `apiVersion: v1
+metadata:
+  name: no-kind
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: valid
+---
+apiVersion: v1
+kind: ConfigMap
+metadata: {}
+---
+kind: [
+`
+	_, err := Split(strings.NewReader(input), "input.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "document 0 in input.yaml: missing 'kind'")
+	require.Contains(t, err.Error(), "document 2 in input.yaml: ConfigMap is missing 'metadata.name'")
+	require.Contains(t, err.Error(), "document 3 in input.yaml: invalid YAML")
+	require.NotContains(t, err.Error(), "document 1")
+}
+
+func TestSplitFlattensLists(t *testing.T) {
+	input := `apiVersion: v1
+kind: List
+items:
+# The first item
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: first
+  data:
+    key: value
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: second
+    namespace: default
+---
+apiVersion: v1
+kind: ServiceList
+metadata:
+  resourceVersion: ""
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: third
+- kind: Service
+`
+	resources, err := Split(strings.NewReader(input), "input.yaml")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "item 1 of document 1 in input.yaml: Service is missing 'metadata.name'")
+	require.Len(t, resources, 3)
+
+	require.Equal(t, "v1/ConfigMap//first", resources[0].Identity())
+	require.Equal(t, "# The first item\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: first\ndata:\n  key: value\n", string(resources[0].Raw))
+	require.Equal(t, "apps/v1/Deployment/default/second", resources[1].Identity())
+	require.Equal(t, "item 1 of document 0 in input.yaml", resources[1].Location())
+	require.Equal(t, "v1/Service//third", resources[2].Identity())
+}
+
+func TestSplitJSON(t *testing.T) {
+	input := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "config"}, "data": {"enabled": "true", "replicas": 3}}
+[
+  {"apiVersion": "v1", "kind": "ServiceAccount", "metadata": {"name": "xyz", "namespace": "default"}},
+  {"apiVersion": "v1", "kind": "List", "items": [{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "nginx"}}]}
+]
+{"apiVersion": "v1", "kind": "List", "items": [{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "nginx"}}]}
+`
+	resources, err := Split(strings.NewReader(input), "input.json")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "item 1 of document 1 in input.json: List is missing 'metadata.name'")
+	require.Len(t, resources, 3)
+
+	require.Equal(t, "v1/ConfigMap//config", resources[0].Identity())
+	require.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  enabled: "true"
+  replicas: 3
+`, string(resources[0].Raw))
+	require.Equal(t, "v1/ServiceAccount/default/xyz", resources[1].Identity())
+	require.Equal(t, "item 0 of document 1 in input.json", resources[1].Location())
+	require.Equal(t, "apps/v1/Deployment//nginx", resources[2].Identity())
+	require.Equal(t, "item 0 of document 2 in input.json", resources[2].Location())
+
+	_, err = Split(strings.NewReader(`{"apiVersion": "v1", "kind": "ConfigMap"`), "broken.json")
+	require.ErrorContains(t, err, "document 0 in broken.json: invalid JSON")
+}
@@ -0,0 +1,53 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Write stores each resource in its own file under dir, named by namer, and returns the paths written.
+// The other files of an earlier run are removed, see RemoveManifests.
+func Write(dir string, resources []*Resource, namer *Namer) ([]string, error) {
+	names, err := namer.Names(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RemoveManifests(dir, names...); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(resources))
+	for i, resource := range resources {
+		path := filepath.Join(dir, names[i])
+		if err := os.WriteFile(path, resource.Raw, 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// RemoveManifests removes the YAML files of dir but those named keep, which are rewritten in place.
+// Files left over from an earlier run would otherwise be indexed along with the new ones once
+// resources or their names change.
+func RemoveManifests(dir string, keep ...string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if slices.Contains(keep, filepath.Base(path)) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
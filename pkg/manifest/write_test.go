@@ -0,0 +1,53 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "generated")
+	resources := []*Resource{
+		{Kind: "Role", Name: "reader", Namespace: "a", Raw: []byte("a\n")},
+		{Kind: "Role", Name: "reader", Namespace: "b", Raw: []byte("b\n")},
+	}
+
+	namer, err := NewNamer("")
+	require.NoError(t, err)
+	paths, err := Write(dir, resources, namer)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "reader-role.yaml"), filepath.Join(dir, "reader-role-2.yaml")}, paths)
+	for i, path := range paths {
+		out, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, resources[i].Raw, out)
+	}
+}
+
+func TestWriteRemovesStaleFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "generated")
+	resources := []*Resource{{APIVersion: "apps/v1", Kind: "Deployment", Name: "nginx", Raw: []byte("a\n")}}
+
+	namer, err := NewNamer("")
+	require.NoError(t, err)
+	_, err = Write(dir, resources, namer)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("kept\n"), 0644))
+
+	// Writing again with another naming template leaves only the new files.
+	namer, err = NewNamer("{{ .kind | lower }}-{{ .name }}")
+	require.NoError(t, err)
+	paths, err := Write(dir, resources, namer)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "deployment-nginx.yaml")}, paths)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"deployment-nginx.yaml", "notes.txt"}, names)
+}
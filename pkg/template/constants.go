@@ -1,7 +1,8 @@
 package template
 
 const (
-	defaultIndent = "  "
+	defaultIndent     = "  "
+	documentSeparator = "---"
 )
 
 const (
@@ -13,8 +14,12 @@ const (
 )
 
 const (
-	slicePrefixFirstLineFormat = "\n- "
-	slicePrefixOtherLineFormat = "- "
+	slicePrefixFormat = "- "
+)
+
+const (
+	nullTag   = "!!null"
+	stringTag = "!!str"
 )
 
 const (
@@ -2,12 +2,14 @@ package template
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"reflect"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/dlclark/regexp2"
@@ -15,15 +17,19 @@ import (
 	"github.com/yeahdongcn/kustohelmize/internal/third_party/dep/fs"
 	"github.com/yeahdongcn/kustohelmize/pkg/chart"
 	"github.com/yeahdongcn/kustohelmize/pkg/config"
+	"github.com/yeahdongcn/kustohelmize/pkg/manifest"
 	"github.com/yeahdongcn/kustohelmize/pkg/util"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 type context struct {
 	out              io.Writer
 	prefix           string
 	fileConfig       config.Config
+	globalConfig     config.Config
 	setRoleNamespace bool
+	// The location of the document, which errors are reported with.
+	location string
 }
 
 type Processor struct {
@@ -32,6 +38,7 @@ type Processor struct {
 	templatesDir      string
 	crdsDir           string
 	suppressNamespace bool
+	preserveKeyOrder  bool
 
 	context context
 }
@@ -68,16 +75,36 @@ func (p *Processor) WithSuppressNamespace(suppress bool) *Processor {
 	return p
 }
 
+func (p *Processor) WithPreserveKeyOrder(preserve bool) *Processor {
+	p.preserveKeyOrder = preserve
+	return p
+}
+
+// Process writes a template for every intermediate file. Every configuration that cannot be
+// applied is reported, with the document, the XPath and the index of the rule, and the other
+// documents are still processed.
 func (p *Processor) Process() error {
-	for source, fileConfig := range p.config.FileConfig {
+	var errs []error
+	for _, source := range p.config.Manifests() {
 		filename := filepath.Base(source)
 
-		if p.suppressNamespace && util.IsNamespaceDefinition(source) {
+		bs, err := os.ReadFile(source)
+		if err != nil {
+			p.logger.Error(err, "Error reading source YAML", "source", source)
+			return err
+		}
+		class, err := classify(bs, source)
+		if err != nil {
+			p.logger.Error(err, "Error classifying source YAML", "source", source)
+			return err
+		}
+
+		if p.suppressNamespace && class == manifest.ClassNamespace {
 			// Don't emit namespaces
 			continue
 		}
 
-		if util.IsCustomResourceDefinition(source) {
+		if class == manifest.ClassCustomResourceDefinition {
 			if err := os.MkdirAll(p.crdsDir, 0755); err != nil {
 				p.logger.Error(err, "Failed to create CRD directory")
 				return err
@@ -103,29 +130,159 @@ func (p *Processor) Process() error {
 			p.logger.Error(err, "Error writing dest file header", "dest", dest)
 		}
 
-		bs, err := os.ReadFile(source)
+		documents, err := decodeDocuments(bs)
 		if err != nil {
-			p.logger.Error(err, "Error reading source YAML", "source", source)
+			p.logger.Error(err, "Error unmarshalling source YAML", "source", source)
 			return err
 		}
-		data := config.GenericMap{}
-		err = yaml.Unmarshal(bs, &data)
+
+		for i, data := range documents {
+			if i > 0 {
+				fmt.Fprintln(file, documentSeparator)
+			}
+			location := (&manifest.Resource{Source: source, Index: i}).Location()
+			fileConfig, err := p.config.DocumentConfig(source, i, data)
+			if err != nil {
+				p.logger.Error(err, "Error expanding file config", "source", source)
+				errs = append(errs, err)
+			}
+			globalConfig, err := config.ExpandSharedConfig(p.config.GlobalConfig, data)
+			if err != nil {
+				p.logger.Error(err, "Error expanding global config", "source", source)
+				errs = append(errs, fmt.Errorf("%s: globalConfig: %w", location, err))
+			}
+			p.context = context{
+				out:              file,
+				prefix:           util.LowerCamelFilenameWithoutExt(source),
+				fileConfig:       fileConfig,
+				globalConfig:     globalConfig,
+				setRoleNamespace: false,
+				location:         location,
+			}
+			if err := p.walk(data, 0, config.XPathRoot, config.XPathSliceIndexNone, ""); err != nil {
+				p.logger.Error(err, "Error processing source YAML", "source", source)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Report an error of the rule at index of the configuration of xpath in the current document.
+// The section is empty for the configuration of the document, see ChartConfig.DocumentConfig.
+func (p *Processor) ruleError(section string, xpath config.XPath, index int, err error) error {
+	location := p.context.location
+	if section != "" {
+		location += ": " + section
+	}
+	return fmt.Errorf("%s: XPath '%s' rule %d: %w", location, xpath, index, err)
+}
+
+// Decode every non-empty document of a (possibly multi-document) YAML file.
+func decodeDocuments(bs []byte) ([]*yaml.Node, error) {
+	documents := []*yaml.Node{}
+	decoder := yaml.NewDecoder(bytes.NewReader(bs))
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			p.logger.Error(err, "Error unmarshalling source YAML", "source", source)
-			return err
+			return nil, err
+		}
+		if len(document.Content) > 0 && util.ResolveNode(document.Content[0]).Kind == yaml.MappingNode {
+			documents = append(documents, document)
+		}
+	}
+	return documents, nil
+}
+
+// Classify an intermediate file by the apiVersion and kind of its first resource.
+func classify(bs []byte, source string) (manifest.Class, error) {
+	resources, err := manifest.Split(bytes.NewReader(bs), source)
+	if err != nil {
+		return "", err
+	}
+	if len(resources) == 0 {
+		return manifest.ClassGeneric, nil
+	}
+	return resources[0].Class(), nil
+}
+
+type pair struct {
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+// Pair up the keys and values of a mapping node in the order they are emitted.
+func (p *Processor) pairs(node *yaml.Node, root config.XPath) []pair {
+	values := map[string]pair{}
+	keys := []string{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		values[key.Value] = pair{key: key, value: node.Content[i+1]}
+		keys = append(keys, key.Value)
+	}
+
+	pairs := []pair{}
+	if p.preserveKeyOrder {
+		for _, key := range keys {
+			pairs = append(pairs, values[key])
 		}
+		return pairs
+	}
+	for _, key := range util.SortKeys(keys, string(root)) {
+		pairs = append(pairs, values[key])
+	}
+	return pairs
+}
+
+// Escape template delimiters in a comment copied from the source manifest.
+func escapeComment(comment string) string {
+	return strings.ReplaceAll(comment, "{{", `{{ "{{" }}`)
+}
 
-		p.context = context{
-			out:              file,
-			prefix:           util.LowerCamelFilenameWithoutExt(source),
-			fileConfig:       fileConfig,
-			setRoleNamespace: false,
+// Format a comment to be appended to the current line.
+func lineComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return " " + escapeComment(comment)
+}
+
+// Format a (possibly multi-line) comment as standalone lines.
+func blockComment(comment string) string {
+	block := ""
+	for _, line := range strings.Split(comment, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			block += escapeComment(line) + "\n"
 		}
-		d := reflect.ValueOf(data)
-		p.walk(d, 0, config.XPathRoot, config.XPathSliceIndexNone)
 	}
+	return block
+}
 
-	return nil
+// Emit a (possibly multi-line) comment on its own lines.
+func (p *Processor) printComment(comment string, nindent int) {
+	if block := blockComment(comment); block != "" {
+		fmt.Fprintln(p.context.out, indent(block, nindent))
+	}
+}
+
+// Collect the comments to be emitted above the "- " of a slice element. The
+// comment of the first key of a map element is moved there too, as it would
+// otherwise end up between the "- " and the key.
+func (p *Processor) elementHeadComments(item *yaml.Node, xpath config.XPath) string {
+	comments := blockComment(item.HeadComment)
+	v := util.ResolveNode(item)
+	if v.Kind == yaml.MappingNode {
+		if pairs := p.pairs(v, xpath); len(pairs) > 0 {
+			comments += blockComment(pairs[0].key.HeadComment)
+			pairs[0].key.HeadComment = ""
+		}
+	}
+	return comments
 }
 
 func indent(s string, n int) string {
@@ -150,19 +307,20 @@ func indentsFromSlice(s string, n int, fromSlice bool) string {
 }
 
 // Emit a scalar slice value
-func (p *Processor) printSliceScalar(str string, nindent int) {
+func (p *Processor) printSliceScalar(str string, nindent int, comment string) {
+	var value string
 	if str == "" {
-		fmt.Fprintln(p.context.out, indent(fmt.Sprintf("- \"%s\"", str), nindent))
+		value = fmt.Sprintf("- \"%s\"", str)
 	} else if str == "*" {
-		fmt.Fprintln(p.context.out, indent(fmt.Sprintf("- '%s'", str), nindent))
+		value = fmt.Sprintf("- '%s'", str)
 	} else {
-		fmt.Fprintln(p.context.out, indent(fmt.Sprintf("- %s", str), nindent))
+		value = fmt.Sprintf("- %s", str)
 	}
-
+	fmt.Fprintln(p.context.out, indent(value, nindent)+lineComment(comment))
 }
 
-// Perform regex substitution. Die if the regex system errors
-func mustReplace(rx *regexp2.Regexp, str string, replacement string) string {
+// Perform regex substitution.
+func replace(rx *regexp2.Regexp, str string, replacement string) (string, error) {
 	replaced, err := rx.ReplaceFunc(str, func(m regexp2.Match) string {
 		// No additional checking here as existence of only one capture group already asserted.
 		groups := m.Groups()
@@ -177,18 +335,21 @@ func mustReplace(rx *regexp2.Regexp, str string, replacement string) string {
 	}, -1, -1)
 
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
-	return replaced
+	return replaced, nil
 }
 
-func (p *Processor) processSliceElement(v reflect.Value, xpath config.XPath, i int, nindent int, nested bool) bool {
+func (p *Processor) processSliceElement(node *yaml.Node, xpath config.XPath, i int, nindent int, nested bool) (bool, error) {
 	useCondition := false
 
-	startCondition := func(xc config.XPathConfig) bool {
+	startCondition := func(xc config.XPathConfig) (bool, error) {
 		if xc.Strategy == config.XPathStrategyControlIf {
-			condition, not := p.config.GetFormattedCondition(&xc, p.context.prefix)
+			condition, not, err := p.config.GetFormattedCondition(&xc, p.context.prefix)
+			if err != nil {
+				return false, p.ruleError("", xpath.NewElement(i), 0, err)
+			}
 			if condition != "" {
 				var value string
 				if !nested && i == 0 {
@@ -200,10 +361,10 @@ func (p *Processor) processSliceElement(v reflect.Value, xpath config.XPath, i i
 				}
 				value += fmt.Sprintf(format, condition)
 				fmt.Fprintln(p.context.out, indent(value, nindent))
-				return true
+				return true, nil
 			}
 		}
-		return false
+		return false, nil
 	}
 
 	endCondition := func() {
@@ -212,49 +373,63 @@ func (p *Processor) processSliceElement(v reflect.Value, xpath config.XPath, i i
 		}
 	}
 
-	processElement := func() {
+	processElement := func() error {
+		item := node.Content[i]
+		comments := p.elementHeadComments(item, xpath)
 		if i == 0 && !useCondition {
-			fmt.Fprint(p.context.out, indent(slicePrefixFirstLineFormat, nindent))
+			fmt.Fprint(p.context.out, indent("\n"+comments+slicePrefixFormat, nindent))
 		} else {
-			fmt.Fprint(p.context.out, indent(slicePrefixOtherLineFormat, nindent))
+			fmt.Fprint(p.context.out, indent(comments+slicePrefixFormat, nindent))
 		}
-		p.walk(v.Index(i), nindent+1, xpath, i)
+		err := p.walk(item, nindent+1, xpath, i, "")
+		p.printComment(item.FootComment, nindent)
+		return err
 	}
 
-	itemXpathConfigs := p.context.fileConfig[xpath.NewElement(i)]
+	itemXpathConfigs := p.fileXPathConfigs(xpath.NewElement(i))
 	if len(itemXpathConfigs) > 0 {
-		useCondition = startCondition(itemXpathConfigs[0])
+		var err error
+		if useCondition, err = startCondition(itemXpathConfigs[0]); err != nil {
+			return true, err
+		}
 
 		if nested {
-			processElement()
+			err := processElement()
 			endCondition()
-			return true
+			return true, err
 		}
 	}
 
 	if !nested {
-		processElement()
+		err := processElement()
 		endCondition()
-		return true
+		return true, err
 	}
 
-	return false
+	return false, nil
 }
 
 // Process a slice of scalars
-func (p *Processor) processSlice(v reflect.Value, xpath config.XPath, nindent int) {
-	xpathConfigs := p.context.fileConfig[xpath]
-	for i := 0; i < v.Len(); i++ {
-		if ok := p.processSliceElement(v, xpath, i, nindent, true); ok {
+func (p *Processor) processSlice(node *yaml.Node, xpath config.XPath, nindent int) error {
+	var errs []error
+	xpathConfigs := p.fileXPathConfigs(xpath)
+	for i := range node.Content {
+		ok, err := p.processSliceElement(node, xpath, i, nindent, true)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if ok {
 			continue
 		}
 
-		item := util.ReflectValue(v.Index(i))
-		str := item.String()
+		p.printComment(node.Content[i].HeadComment, nindent)
+		item := util.ResolveNode(node.Content[i])
+		str := item.Value
+		comment := node.Content[i].LineComment
 		if len(xpathConfigs) == 0 {
-			p.printSliceScalar(str, nindent)
+			p.printSliceScalar(str, nindent, comment)
 		} else {
-			for _, xpathConfig := range xpathConfigs {
+			for index, xpathConfig := range xpathConfigs {
 				if xpathConfig.Strategy != config.XPathStrategyInlineRegex {
 					continue
 				}
@@ -264,7 +439,11 @@ func (p *Processor) processSlice(v reflect.Value, xpath config.XPath, nindent in
 				}
 				// Match against xpathConfig.RegexCompiled and do replacement.
 				var value string
-				key, keyType := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
+				key, keyType, err := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
+				if err != nil {
+					errs = append(errs, p.ruleError("", xpath, index, err))
+					break
+				}
 				if keyType.IsHelpersType() {
 					// name: {{ include "mychart.fullname" . }}
 					value = fmt.Sprintf(singleIncludeFormat, key)
@@ -273,37 +452,57 @@ func (p *Processor) processSlice(v reflect.Value, xpath config.XPath, nindent in
 					value = fmt.Sprintf(singleValueFormat, key)
 				}
 				// Replace now
-				str = mustReplace(rx, str, value)
+				replaced, err := replace(rx, str, value)
+				if err != nil {
+					errs = append(errs, p.ruleError("", xpath, index, err))
+					break
+				}
+				str = replaced
 				break
 			}
-			p.printSliceScalar(str, nindent)
+			p.printSliceScalar(str, nindent, comment)
 		}
+		p.printComment(node.Content[i].FootComment, nindent)
 	}
+	return errors.Join(errs...)
 }
 
-func (p *Processor) processMapOrDie(k reflect.Value, v reflect.Value, nindent int,
-	xpath config.XPath, xpathConfigs config.XPathConfigs, hasSliceIndex bool) bool {
+// Apply the configuration of the value v of the key k at xpath, reporting whether it was applied.
+// Nothing is written if the configuration cannot be applied.
+func (p *Processor) processXPathConfigs(k string, v *yaml.Node, nindent int,
+	xpath config.XPath, section string, xpathConfigs config.XPathConfigs, hasSliceIndex bool) (bool, error) {
 	if len(xpathConfigs) == 0 {
-		return false
+		return false, nil
 	}
 	xpathConfig := xpathConfigs[0]
+	if !slices.Contains(config.XPathStrategies, xpathConfig.Strategy) {
+		return false, p.ruleError(section, xpath, 0, fmt.Errorf("unknown strategy '%s'", xpathConfig.Strategy))
+	}
+	key, keyType, err := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
+	if err != nil {
+		return false, p.ruleError(section, xpath, 0, err)
+	}
 	switch xpathConfig.Strategy {
 	case config.XPathStrategyInline, config.XPathStrategyInlineYAML:
-		key := fmt.Sprintf(singleLineKeyFormat, k)
-		fmt.Fprint(p.context.out, indentsFromSlice(key, nindent, hasSliceIndex))
-
 		var value string
-		key, keyType := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
 		if keyType.IsHelpersType() {
 			// name: {{ include "mychart.fullname" . }}
 			value = fmt.Sprintf(singleIncludeFormat, key)
 		} else if len(xpathConfigs) > 1 {
 			// image: "{{ .Values.image.repository }}:{{ .Values.image.tag | default .Chart.AppVersion }}"
-			for _, xpc := range xpathConfigs {
-				key, _ := p.config.GetFormattedKeyWithDefaultValue(&xpc, p.context.prefix)
+			var errs []error
+			for index, xpc := range xpathConfigs {
+				key, _, err := p.config.GetFormattedKeyWithDefaultValue(&xpc, p.context.prefix)
+				if err != nil {
+					errs = append(errs, p.ruleError(section, xpath, index, err))
+					continue
+				}
 				value += fmt.Sprintf(singleValueFormat, key)
 				value += config.MultiValueSeparator
 			}
+			if len(errs) > 0 {
+				return false, errors.Join(errs...)
+			}
 			value = fmt.Sprintf("\"%s\"", strings.TrimRight(value, config.MultiValueSeparator))
 		} else if xpathConfig.Strategy == config.XPathStrategyInline {
 			// imagePullPolicy: {{ .Values.image.pullPolicy }}
@@ -318,14 +517,13 @@ func (p *Processor) processMapOrDie(k reflect.Value, v reflect.Value, nindent in
 			// imagePullPolicy: {{ toYaml .Values.image.pullPolicy }}
 			value = fmt.Sprintf(singleValueFormat, key)
 		}
+		fmt.Fprint(p.context.out, indentsFromSlice(fmt.Sprintf(singleLineKeyFormat, k), nindent, hasSliceIndex))
 		fmt.Fprintln(p.context.out, value)
-		return true
+		return true, nil
 	case config.XPathStrategyNewline, config.XPathStrategyNewlineYAML:
-		key := fmt.Sprintf(newlineKeyFormat, k)
-		fmt.Fprintln(p.context.out, indentsFromSlice(key, nindent, hasSliceIndex))
+		fmt.Fprintln(p.context.out, indentsFromSlice(fmt.Sprintf(newlineKeyFormat, k), nindent, hasSliceIndex))
 
 		var value string
-		key, keyType := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
 		if keyType.IsHelpersType() {
 			// selector:
 			//   {{- include "mychart.selectorLabels" . | nindent 4 }}
@@ -340,19 +538,20 @@ func (p *Processor) processMapOrDie(k reflect.Value, v reflect.Value, nindent in
 			value = fmt.Sprintf(newlineYAMLValueFormat, key, (nindent+1)*2)
 		}
 		fmt.Fprintln(p.context.out, indent(value, nindent+1))
-		return true
+		return true, nil
 	case config.XPathStrategyControlWith:
-		key, _ := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
 		// {{- with .Values.tolerations }}
 		// tolerations:
 		//   {{- toYaml . | nindent 8 }}
 		// {{- end }}
 		value := fmt.Sprintf(withFormat, key, k, (nindent+1)*2)
 		fmt.Fprintln(p.context.out, indentsFromSlice(value, nindent, hasSliceIndex))
-		return true
+		return true, nil
 	case config.XPathStrategyControlIf, config.XPathStrategyControlIfYAML:
-		key, _ := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
-		condition, not := p.config.GetFormattedCondition(&xpathConfig, p.context.prefix)
+		condition, not, err := p.config.GetFormattedCondition(&xpathConfig, p.context.prefix)
+		if err != nil {
+			return false, p.ruleError(section, xpath, 0, err)
+		}
 		if condition == "" {
 			// If no condition is specified, fall back to the key
 			condition = key
@@ -361,7 +560,13 @@ func (p *Processor) processMapOrDie(k reflect.Value, v reflect.Value, nindent in
 
 		var value string
 		if key == "" && condition != "" {
-			vStr := util.ToStringOrDie(v)
+			if !p.preserveKeyOrder {
+				v = util.SortedNode(v)
+			}
+			vStr, err := util.ToString(v)
+			if err != nil {
+				return false, p.ruleError(section, xpath, 0, err)
+			}
 			format := ifOriginFormat
 			if not {
 				format = ifNotOriginFormat
@@ -383,112 +588,151 @@ func (p *Processor) processMapOrDie(k reflect.Value, v reflect.Value, nindent in
 			}
 		}
 		fmt.Fprintln(p.context.out, indentsFromSlice(value, nindent, hasSliceIndex))
-		return true
+		return true, nil
 	case config.XPathStrategyControlRange:
-		key, _ := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
 		// {{- range .Values.imagePullSecrets }}
 		//   - name: {{ . }}
 		// {{- end }}
 		value := fmt.Sprintf(rangeFormat, k, key)
 		fmt.Fprintln(p.context.out, indentsFromSlice(value, nindent, hasSliceIndex))
-		return true
+		return true, nil
 	case config.XPathStrategyFileIf:
-		key, _ := p.config.GetFormattedKeyWithDefaultValue(&xpathConfig, p.context.prefix)
 		fmt.Fprintf(p.context.out, fileIfFormat, key)
-		return true
-	case config.XPathStrategyInlineRegex:
-		// Processed by slice
-		return false
-	case config.XPathStrategyAppendWith:
-		// Processed by slice
-		return false
+		return true, nil
 	default:
-		panic(fmt.Sprintf("Unknown XPath strategy: %s", xpathConfig.Strategy))
+		// inline-regex and append-with are processed by slice
+		return false, nil
 	}
 }
 
-func (p *Processor) processMap(k reflect.Value, v reflect.Value, nindent int, xpath config.XPath, hasSliceIndex *bool) bool {
+// Look up the file config of the current document, with its patterns expanded.
+func (p *Processor) fileXPathConfigs(xpath config.XPath) config.XPathConfigs {
+	return p.context.fileConfig[xpath]
+}
+
+func (p *Processor) processMap(k string, v *yaml.Node, nindent int, xpath config.XPath, hasSliceIndex *bool) (bool, error) {
 	// XXX: The priority of file config is greater than global config.
-	if p.processMapOrDie(k, v, nindent, xpath, p.context.fileConfig[xpath], *hasSliceIndex) {
+	processed, err := p.processXPathConfigs(k, v, nindent, xpath, "", p.fileXPathConfigs(xpath), *hasSliceIndex)
+	if err != nil {
+		return false, err
+	}
+	if processed {
 		p.logger.V(10).Info("Processed map for file config", "xpath", xpath)
 		// XXX: For the first element only.
 		if *hasSliceIndex {
 			*hasSliceIndex = false
 		}
-		return true
+		return true, nil
 	}
-	if p.processMapOrDie(k, v, nindent, xpath, p.config.GlobalConfig[xpath], *hasSliceIndex) {
+	processed, err = p.processXPathConfigs(k, v, nindent, xpath, "globalConfig", p.context.globalConfig[xpath], *hasSliceIndex)
+	if err != nil {
+		return false, err
+	}
+	if processed {
 		p.logger.V(10).Info("Processed map for global config", "xpath", xpath)
 		// XXX: For the first element only.
 		if *hasSliceIndex {
 			*hasSliceIndex = false
 		}
-		return true
+		return true, nil
 	}
 
-	return false
+	return false, nil
 }
 
-func (p *Processor) walk(v reflect.Value, nindent int, root config.XPath, sliceIndex int) {
-
-	if root.IsRoot() {
+// Write the template of node. Configurations that cannot be applied are reported, and the node
+// they apply to is written as it is.
+func (p *Processor) walk(node *yaml.Node, nindent int, root config.XPath, sliceIndex int, comment string) error {
+	if node.Kind == yaml.DocumentNode {
 		// Process root level map for existence of file-if
 		hasSliceIndex := false
-		if p.processMap(reflect.ValueOf(""), reflect.ValueOf(""), 0, root, &hasSliceIndex) {
+		processed, err := p.processMap("", nil, 0, root, &hasSliceIndex)
+		if processed {
 			defer fmt.Fprintln(p.context.out, endDelimited)
 		}
+		p.printComment(node.HeadComment, 0)
+		errs := []error{err, p.walk(node.Content[0], nindent, root, sliceIndex, "")}
+		p.printComment(node.FootComment, 0)
+		return errors.Join(errs...)
 	}
 
-	v = util.ReflectValue(v)
-	switch v.Kind() {
-	case reflect.Array, reflect.Slice:
+	var errs []error
+
+	v := util.ResolveNode(node)
+	switch v.Kind {
+	case yaml.SequenceNode:
 		p.logger.V(10).Info("Processing slice", "root", root)
 
 		keepWalking := false
-		if v.Len() > 0 {
-			first := util.ReflectValue(v.Index(0))
-			if first.Kind() == reflect.Map {
+		if len(v.Content) > 0 {
+			first := util.ResolveNode(v.Content[0])
+			if first.Kind == yaml.MappingNode {
 				// XXX: If this is a slice of maps, we need to process them separately.
 				keepWalking = true
 			}
 		}
 		if !root.IsRoot() && !keepWalking {
-			fmt.Fprintln(p.context.out)
+			fmt.Fprintln(p.context.out, escapeComment(comment))
+		} else {
+			fmt.Fprint(p.context.out, escapeComment(comment))
 		}
 		if !keepWalking {
-			p.processSlice(v, root, nindent)
+			errs = append(errs, p.processSlice(v, root, nindent))
 		} else {
-			for i := 0; i < v.Len(); i++ {
-				p.processSliceElement(v, root, i, nindent, false)
+			for i := range v.Content {
+				_, err := p.processSliceElement(v, root, i, nindent, false)
+				errs = append(errs, err)
 			}
-			rootXpathConfigs := p.context.fileConfig[root]
+			rootXpathConfigs := p.fileXPathConfigs(root)
 			if len(rootXpathConfigs) > 0 {
 				rootXpathConfig := rootXpathConfigs[0]
 				if rootXpathConfig.Strategy == config.XPathStrategyAppendWith {
-					key, _ := p.config.GetFormattedKeyWithDefaultValue(&rootXpathConfig, p.context.prefix)
-					value := fmt.Sprintf(appendWithFormat, key, nindent*2)
-					fmt.Fprintln(p.context.out, indent(value, nindent))
+					key, _, err := p.config.GetFormattedKeyWithDefaultValue(&rootXpathConfig, p.context.prefix)
+					if err != nil {
+						errs = append(errs, p.ruleError("", root, 0, err))
+					} else {
+						value := fmt.Sprintf(appendWithFormat, key, nindent*2)
+						fmt.Fprintln(p.context.out, indent(value, nindent))
+					}
 				}
 			}
 		}
-	case reflect.Map:
+	case yaml.MappingNode:
 		p.logger.V(10).Info("Processing map", "root", root)
 
-		kvs := util.SortedMapKeys(v, string(root))
+		pairs := p.pairs(v, root)
 		if !root.IsRoot() && sliceIndex == config.XPathSliceIndexNone {
-			if len(kvs) > 0 {
-				fmt.Fprintln(p.context.out)
+			if len(pairs) > 0 {
+				fmt.Fprintln(p.context.out, escapeComment(comment))
 			} else {
 				// Handle empty map
-				fmt.Fprintf(p.context.out, "{}\n")
+				fmt.Fprintf(p.context.out, "{}%s\n", lineComment(comment))
+			}
+		}
+		for _, pair := range pairs {
+			xpath := root.NewChild(pair.key.Value, sliceIndex)
+			if p.suppressNamespace && roleSubjectRegex.MatchString(string(xpath)) && util.ResolveNode(pair.value).Value == "ServiceAccount" {
+				// This is a bit mucky.
+				// Here we are inside a subjects block of a role/customrole binding.
+				// It is for a service account.
+				// Therefore we know that the next 'namespace' key encountered will be for this subject,
+				// wherever it is placed in the map.
+				p.context.setRoleNamespace = true
 			}
 		}
 		hasSliceIndex := sliceIndex != config.XPathSliceIndexNone
-		for _, kv := range kvs {
-			mapKey := util.ReflectValue(kv).String()
+		for _, pair := range pairs {
+			mapKey := pair.key.Value
 			xpath := root.NewChild(mapKey, sliceIndex)
-			if !p.processMap(kv, v.MapIndex(kv), nindent, xpath, &hasSliceIndex) {
-				key := fmt.Sprintf(singleLineKeyFormat, kv)
+			p.printComment(pair.key.HeadComment, nindent)
+			processed, err := p.processMap(mapKey, pair.value, nindent, xpath, &hasSliceIndex)
+			errs = append(errs, err)
+			if processed {
+				// Comments on the line of a replaced value are kept on their own line.
+				p.printComment(pair.key.LineComment, nindent)
+				p.printComment(pair.value.LineComment, nindent)
+			} else {
+				key := fmt.Sprintf(singleLineKeyFormat, mapKey)
 				if hasSliceIndex {
 					fmt.Fprint(p.context.out, indentsFromSlice(key, nindent, true))
 					// XXX: For the first element only.
@@ -496,39 +740,38 @@ func (p *Processor) walk(v reflect.Value, nindent int, root config.XPath, sliceI
 				} else {
 					fmt.Fprint(p.context.out, indent(key, nindent))
 				}
-				p.walk(v.MapIndex(kv), nindent+1, xpath, config.XPathSliceIndexNone)
+				errs = append(errs, p.walk(pair.value, nindent+1, xpath, config.XPathSliceIndexNone, pair.key.LineComment))
 			}
+			p.printComment(pair.key.FootComment, nindent)
+			p.printComment(pair.value.FootComment, nindent)
 		}
 	default:
+		comment = lineComment(comment) + lineComment(node.LineComment)
 		if p.suppressNamespace && (strings.HasSuffix(string(root), "metadata.namespace") || (p.context.setRoleNamespace && strings.HasSuffix(string(root), "namespace"))) {
 			// Use helm's idea of what the namespace is
 			fmt.Fprintf(p.context.out, singleValueFormat, ".Release.Namespace")
-			fmt.Fprintln(p.context.out)
+			fmt.Fprintln(p.context.out, comment)
 			p.context.setRoleNamespace = false
-			return
+			return nil
 		}
 		// spec.template.spec.nodeSelector: Invalid type. Expected: [string,null], given: boolean
-		if v.Kind() == reflect.Invalid {
-			fmt.Fprintln(p.context.out, "null")
-			return
-		}
-		s := v.String()
-		if p.suppressNamespace && roleSubjectRegex.MatchString(string(root)) && s == "ServiceAccount" {
-			// This is a bit mucky.
-			// Here we are inside a subjects block of a role/customrole binding.
-			// It is for a service account.
-			// Order of keys is guaranteed.
-			// Therefore we know that the next 'namespace' key encountered will be for this subject.
-			p.context.setRoleNamespace = true
+		if v.Tag == nullTag {
+			fmt.Fprintln(p.context.out, "null"+comment)
+			return nil
 		}
+		s := v.Value
 		p.logger.V(10).Info("Processing others", "root", root, "s", s)
 		str := util.String(s)
-		if str.IsBool() || str.IsNumeric() || str.IsWhiteSpace() {
-			fmt.Fprintf(p.context.out, "\"%s\"\n", v)
+		if v.Tag != stringTag {
+			// Booleans, numbers and custom tags are written as they are.
+			fmt.Fprintln(p.context.out, s+comment)
+		} else if str.IsBool() || str.IsNumeric() || str.IsWhiteSpace() {
+			fmt.Fprintf(p.context.out, "\"%s\"%s\n", s, comment)
 		} else if str.HasNewLine() {
-			fmt.Fprintf(p.context.out, "|\n%s\n", indent(s, nindent+1))
+			fmt.Fprintf(p.context.out, "|%s\n%s\n", comment, indent(s, nindent+1))
 		} else {
-			fmt.Fprintln(p.context.out, v)
+			fmt.Fprintln(p.context.out, s+comment)
 		}
 	}
+	return errors.Join(errs...)
 }
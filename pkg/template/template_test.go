@@ -2,10 +2,17 @@ package template
 
 import (
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/dlclark/regexp2"
 	"github.com/stretchr/testify/require"
+	"github.com/yeahdongcn/kustohelmize/pkg/config"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 type regexTest struct {
@@ -35,7 +42,391 @@ func TestRegexReplace(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(fmt.Sprintf("%s <- %s", testCase.expression, testCase.replacement), func(t *testing.T) {
 			rx := regexp2.MustCompile(testCase.expression, regexp2.Multiline)
-			require.Equal(t, testCase.expected, mustReplace(rx, testCase.input, testCase.replacement))
+			actual, err := replace(rx, testCase.input, testCase.replacement)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expected, actual)
 		})
 	}
 }
+
+// Write files, by name, to the intermediate directory of a chart, index them and template them with
+// chartConfig, which may refer to them with path keys relative to the intermediate directory.
+// Options configure the processor further. The files written to the chart are returned by their
+// path in the chart, such as templates/nginx-deployment.yaml, even if processing fails.
+func processTemplates(t *testing.T, files map[string]string, chartConfig *config.ChartConfig, options ...func(*Processor)) (map[string]string, error) {
+	dir := t.TempDir()
+	intermediateDir := filepath.Join(dir, "generated")
+	require.NoError(t, os.MkdirAll(intermediateDir, 0755))
+	for name, content := range files {
+		path := filepath.Join(intermediateDir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		if fileConfig, ok := chartConfig.FileConfig[name]; ok {
+			delete(chartConfig.FileConfig, name)
+			chartConfig.FileConfig[path] = fileConfig
+		}
+	}
+	require.NoError(t, chartConfig.IndexManifests(intermediateDir))
+
+	chartDir := filepath.Join(dir, "chart")
+	templatesDir := filepath.Join(chartDir, "templates")
+	require.NoError(t, os.MkdirAll(templatesDir, 0755))
+	p := NewProcessor().
+		WithLogger(zap.New()).
+		WithChartConfig(chartConfig).
+		WithTemplatesDir(templatesDir).
+		WithCrdsDir(filepath.Join(chartDir, "crds"))
+	for _, option := range options {
+		option(p)
+	}
+	err := p.Process()
+
+	out := map[string]string{}
+	require.NoError(t, filepath.WalkDir(chartDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(chartDir, path)
+		out[filepath.ToSlash(name)] = string(bs)
+		return err
+	}))
+	return out, err
+}
+
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestProcessClassifiesByContent(t *testing.T) {
+	out, err := processTemplates(t, map[string]string{
+		"namespace-system.yaml": "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: system\n",
+		"crd-memcacheds.yaml":   "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: memcacheds.cache.example.com\n",
+		"configmap-config.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n",
+	}, config.NewChartConfig(zap.New(), "chart"), func(p *Processor) { p.WithSuppressNamespace(true) })
+	require.NoError(t, err)
+	require.Equal(t, []string{"crds/crd-memcacheds.yaml", "templates/configmap-config.yaml"}, sortedNames(out))
+}
+
+func TestProcessMultipleDocuments(t *testing.T) {
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.FileConfig["webhook.yaml"] = config.Config{
+		"spec.type": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "webhook.type"},
+		},
+		"$1.spec.type": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "metrics.type"},
+		},
+		"$1": []config.XPathConfig{
+			{Strategy: config.XPathStrategyFileIf, Key: "metrics.enabled"},
+		},
+	}
+	require.NoError(t, chartConfig.Validate())
+	out, err := processTemplates(t, map[string]string{"webhook.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: webhook
+spec:
+  type: ClusterIP
+---
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: metrics
+spec:
+  type: ClusterIP
+`}, chartConfig)
+	require.NoError(t, err)
+
+	documents := strings.Split(out["templates/webhook.yaml"], "\n---\n")
+	require.Len(t, documents, 2)
+	require.Contains(t, documents[0], "type: {{ .Values.webhook.webhook.type }}")
+	require.NotContains(t, documents[0], "{{- if")
+	require.True(t, strings.HasPrefix(documents[1], "{{- if .Values.webhook.metrics.enabled }}\n"))
+	require.Contains(t, documents[1], "type: {{ .Values.webhook.metrics.type }}")
+	require.True(t, strings.HasSuffix(documents[1], "{{- end }}\n"))
+}
+
+func TestProcessPreservesComments(t *testing.T) {
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.FileConfig["nginx.yaml"] = config.Config{
+		"spec.replicas": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "replicas"},
+		},
+	}
+	require.NoError(t, chartConfig.Validate())
+	out, err := processTemplates(t, map[string]string{"nginx.yaml": `# Source: nginx/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata: # object metadata
+  name: nginx
+spec:
+  # Scale with {{ .Values }}
+  replicas: 3 # keep odd
+  template:
+    spec:
+      containers:
+      # The main container
+      - name: nginx
+        image: nginx:latest
+        args:
+        - --verbose # debug only
+`}, chartConfig)
+	require.NoError(t, err)
+
+	template := out["templates/nginx.yaml"]
+	require.Contains(t, template, "# Source: nginx/deployment.yaml\napiVersion: apps/v1\n")
+	require.Contains(t, template, "metadata: # object metadata\n")
+	require.Contains(t, template, `  # Scale with {{ "{{" }} .Values }}
+  replicas: {{ .Values.nginx.replicas }}
+  # keep odd
+`)
+	require.Contains(t, template, `
+        # The main container
+        - name: nginx
+`)
+	require.Contains(t, template, "            - --verbose # debug only\n")
+}
+
+func TestProcessPreserveKeyOrder(t *testing.T) {
+	manifest := `apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  namespace: system
+  name: manager
+subjects:
+- namespace: system
+  name: manager
+  kind: ServiceAccount
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: manager
+`
+	process := func(preserve bool) string {
+		out, err := processTemplates(t, map[string]string{"manager-rb.yaml": manifest}, config.NewChartConfig(zap.New(), "chart"),
+			func(p *Processor) { p.WithSuppressNamespace(true).WithPreserveKeyOrder(preserve) })
+		require.NoError(t, err)
+		return out["templates/manager-rb.yaml"]
+	}
+
+	sorted := process(false)
+	require.Contains(t, sorted, `metadata: 
+  name: {{ include "chart.fullname" . }}
+  namespace: {{ .Release.Namespace }}
+roleRef: `)
+	require.Contains(t, sorted, `
+  - kind: ServiceAccount
+    name: manager
+    namespace: {{ .Release.Namespace }}
+`)
+
+	preserved := process(true)
+	require.Contains(t, preserved, `metadata: 
+  namespace: {{ .Release.Namespace }}
+  name: {{ include "chart.fullname" . }}
+subjects: `)
+	require.Contains(t, preserved, `
+  - namespace: {{ .Release.Namespace }}
+    name: manager
+    kind: ServiceAccount
+roleRef: `)
+}
+
+func TestProcessFileConfigByIdentity(t *testing.T) {
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.FileConfig["apps/v1/Deployment//nginx"] = config.Config{
+		"spec.replicas": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "replicas"},
+		},
+	}
+	require.NoError(t, chartConfig.Validate())
+	out, err := processTemplates(t, map[string]string{"nginx-deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  replicas: 1
+`}, chartConfig)
+	require.NoError(t, err)
+	require.Contains(t, out["templates/nginx-deployment.yaml"], "replicas: {{ .Values.nginxDeployment.replicas }}")
+}
+
+func TestProcessReportsEveryError(t *testing.T) {
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.FileConfig["apps/v1/Deployment//nginx"] = config.Config{
+		"spec.replicas": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "sharedValues.replicas"},
+		},
+		"spec.paused": []config.XPathConfig{
+			{Strategy: "inlin", Key: "paused"},
+		},
+	}
+	_, err := processTemplates(t, map[string]string{"nginx-deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  replicas: 1
+  paused: false
+`}, chartConfig)
+	location := "document 0 in " + chartConfig.Manifests()[0]
+	require.EqualError(t, err, location+": XPath 'spec.paused' rule 0: unknown strategy 'inlin'\n"+
+		location+": XPath 'spec.replicas' rule 0: key 'sharedValues.replicas' not found in sharedValues")
+}
+
+func TestProcessSelectorConfig(t *testing.T) {
+	files := map[string]string{}
+	for _, name := range []string{"nginx", "redis"} {
+		files[name+"-deployment.yaml"] = fmt.Sprintf(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %s
+spec:
+  replicas: 1
+`, name)
+	}
+
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.SelectorConfig = []config.SelectorConfig{
+		{
+			Selector: config.Selector{Kinds: []string{"Deployment"}},
+			Config: config.Config{
+				"spec.replicas": []config.XPathConfig{
+					{Strategy: config.XPathStrategyInline, Key: "replicas"},
+				},
+			},
+		},
+	}
+	chartConfig.FileConfig["apps/v1/Deployment//redis"] = config.Config{
+		"spec.replicas": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "replicaCount"},
+		},
+	}
+	require.NoError(t, chartConfig.Validate())
+	out, err := processTemplates(t, files, chartConfig)
+	require.NoError(t, err)
+
+	require.Contains(t, out["templates/nginx-deployment.yaml"], "replicas: {{ .Values.nginxDeployment.replicas }}")
+	// fileConfig takes precedence over selectorConfig.
+	require.Contains(t, out["templates/redis-deployment.yaml"], "replicas: {{ .Values.redisDeployment.replicaCount }}")
+}
+
+func TestProcessWildcardXPaths(t *testing.T) {
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.FileConfig["apps/v1/Deployment//nginx"] = config.Config{
+		"spec.template.spec.containers[*].image": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "*.image"},
+		},
+	}
+	require.NoError(t, chartConfig.Validate())
+	out, err := processTemplates(t, map[string]string{"nginx-deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  template:
+    spec:
+      containers:
+      - name: kube-rbac-proxy
+        image: kube-rbac-proxy:v0.13.0
+      - image: controller:latest
+        name: manager
+`}, chartConfig)
+	require.NoError(t, err)
+
+	require.Contains(t, out["templates/nginx-deployment.yaml"], "image: {{ .Values.nginxDeployment.kubeRbacProxy.image }}")
+	require.Contains(t, out["templates/nginx-deployment.yaml"], "image: {{ .Values.nginxDeployment.manager.image }}")
+}
+
+func TestProcessSelectorXPaths(t *testing.T) {
+	files := map[string]string{"nginx-deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  template:
+    spec:
+      containers:
+      - name: kube-rbac-proxy
+        image: kube-rbac-proxy:v0.13.0
+      - name: manager
+        image: controller:latest
+        env:
+        - name: WATCH_NAMESPACE
+          value: ""
+        - name: LOG_LEVEL
+          value: info
+`}
+	process := func(fileConfig config.Config) (string, error) {
+		chartConfig := config.NewChartConfig(zap.New(), "chart")
+		chartConfig.FileConfig["apps/v1/Deployment//nginx"] = fileConfig
+		require.NoError(t, chartConfig.Validate())
+		out, err := processTemplates(t, files, chartConfig)
+		return out["templates/nginx-deployment.yaml"], err
+	}
+
+	out, err := process(config.Config{
+		"spec.template.spec.containers[name=manager].image": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "image"},
+		},
+		"spec.template.spec.containers[name=manager].env[name=LOG_LEVEL].value": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "logLevel"},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, out, "image: kube-rbac-proxy:v0.13.0")
+	require.Contains(t, out, "image: {{ .Values.nginxDeployment.image }}")
+	require.Contains(t, out, "value: {{ .Values.nginxDeployment.logLevel }}")
+	require.Contains(t, out, `value: ""`)
+
+	_, err = process(config.Config{
+		"spec.template.spec.containers[name=proxy].image": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "image"},
+		},
+	})
+	require.ErrorContains(t, err, "selector '[name=proxy]' at 'spec.template.spec.containers' matches 0 elements")
+
+	// globalConfig does not apply to workloads without the selected container.
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.GlobalConfig["spec.template.spec.containers[name=proxy].image"] = []config.XPathConfig{
+		{Strategy: config.XPathStrategyInline, Key: "proxy.image"},
+	}
+	templates, err := processTemplates(t, files, chartConfig)
+	require.NoError(t, err)
+	require.Contains(t, templates["templates/nginx-deployment.yaml"], "image: kube-rbac-proxy:v0.13.0")
+}
+
+func TestProcessEscapedKeys(t *testing.T) {
+	chartConfig := config.NewChartConfig(zap.New(), "chart")
+	chartConfig.FileConfig["v1/Service//nginx"] = config.Config{
+		`metadata.annotations["prometheus.io/scrape"]`: []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: `annotations["prometheus.io/scrape"]`},
+		},
+		// The legacy form, which did not quote keys holding a separator, still applies.
+		"metadata.annotations.prometheus.io/port": []config.XPathConfig{
+			{Strategy: config.XPathStrategyInline, Key: "port"},
+		},
+	}
+	require.NoError(t, chartConfig.Validate())
+	out, err := processTemplates(t, map[string]string{"nginx-service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+  annotations:
+    prometheus.io/port: "8443"
+    prometheus.io/scrape: "true"
+`}, chartConfig)
+	require.NoError(t, err)
+
+	require.Contains(t, out["templates/nginx-service.yaml"], `prometheus.io/port: {{ .Values.nginxService.port }}`)
+	require.Contains(t, out["templates/nginx-service.yaml"], `prometheus.io/scrape: {{ (index .Values "nginxService" "annotations" "prometheus.io/scrape") }}`)
+}
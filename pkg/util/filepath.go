@@ -8,11 +8,15 @@ import (
 )
 
 // IsCustomResourceDefinition checks if the file is a Custom Resource Definition (CRD) based on its suffix.
+//
+// Deprecated: File names depend on the naming template, use manifest.Classify instead.
 func IsCustomResourceDefinition(path string) bool {
 	return strings.HasSuffix(path, "-crd.yaml")
 }
 
 // IsNamespaceDefinition checks if the file is a Namespace Definition based on its suffix.
+//
+// Deprecated: File names depend on the naming template, use manifest.Classify instead.
 func IsNamespaceDefinition(path string) bool {
 	return strings.HasSuffix(path, "-namespace.yaml")
 }
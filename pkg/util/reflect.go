@@ -1,12 +1,9 @@
 package util
 
 import (
-	"fmt"
 	"reflect"
 	"regexp"
 	"strings"
-
-	"gopkg.in/yaml.v2"
 )
 
 // Match xPath of containers/initContainers
@@ -51,6 +48,24 @@ func SortedMapKeys(v reflect.Value, root string) []reflect.Value {
 		panic("Expected map")
 	}
 
+	keyValues := map[string]reflect.Value{}
+	keys := []string{}
+	for _, kv := range v.MapKeys() {
+		key := ReflectValue(kv).String()
+		keyValues[key] = kv
+		keys = append(keys, key)
+	}
+
+	sorted := []reflect.Value{}
+	for _, key := range SortKeys(keys, root) {
+		sorted = append(sorted, keyValues[key])
+	}
+	return sorted
+}
+
+// SortKeys sorts the keys of the map at XPath root in place the same way as SortedMapKeys.
+func SortKeys(keys []string, root string) []string {
+
 	// Determine ordering from current XPath
 
 	order := manifestFirst
@@ -61,13 +76,11 @@ func SortedMapKeys(v reflect.Value, root string) []reflect.Value {
 		order = metadataFirst
 	}
 
-	keyValues := v.MapKeys()
-
 	// Rubbish bubble sort - but there's never more than 20 odd keys to sort.
-	for i := 0; i < len(keyValues); i++ {
-		for j := 0; j < len(keyValues)-i-1; j++ {
-			j0 := ReflectValue(keyValues[j]).String()
-			j1 := ReflectValue(keyValues[j+1]).String()
+	for i := 0; i < len(keys); i++ {
+		for j := 0; j < len(keys)-i-1; j++ {
+			j0 := keys[j]
+			j1 := keys[j+1]
 
 			if v, ok := order[j0]; ok {
 				j0 = v
@@ -78,22 +91,10 @@ func SortedMapKeys(v reflect.Value, root string) []reflect.Value {
 			}
 
 			if j0 > j1 {
-				keyValues[j], keyValues[j+1] = keyValues[j+1], keyValues[j]
+				keys[j], keys[j+1] = keys[j+1], keys[j]
 			}
 		}
 	}
 
-	return keyValues
-}
-
-func ToStringOrDie(v reflect.Value) string {
-	out, err := yaml.Marshal(v.Interface())
-	if err != nil {
-		panic(err)
-	}
-	ret := strings.TrimRight(string(out), "\n")
-	if strings.Contains(ret, "\n") {
-		ret = fmt.Sprintf("\n%s", ret)
-	}
-	return ret
+	return keys
 }
@@ -0,0 +1,42 @@
+package util
+
+import "strings"
+
+// ClosestMatch returns the candidate that s was most likely meant to be, for "did you mean" hints.
+// ok is false if no candidate is close enough.
+func ClosestMatch(s string, candidates []string) (match string, ok bool) {
+	best := -1
+	for _, candidate := range candidates {
+		distance := levenshtein(strings.ToLower(s), strings.ToLower(candidate))
+		// Allow roughly one typo per three characters.
+		if distance > max(len(candidate)/3, 1) {
+			continue
+		}
+		if best == -1 || distance < best {
+			best = distance
+			match = candidate
+		}
+	}
+	return match, best != -1
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
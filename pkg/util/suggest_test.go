@@ -0,0 +1,26 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClosestMatch(t *testing.T) {
+	candidates := []string{"inline", "inline-yaml", "newline", "control-if", "control-if-yaml"}
+
+	match, ok := ClosestMatch("inlne", candidates)
+	require.True(t, ok)
+	require.Equal(t, "inline", match)
+
+	match, ok = ClosestMatch("control_if", candidates)
+	require.True(t, ok)
+	require.Equal(t, "control-if", match)
+
+	match, ok = ClosestMatch("Newline", candidates)
+	require.True(t, ok)
+	require.Equal(t, "newline", match)
+
+	_, ok = ClosestMatch("range", candidates)
+	require.False(t, ok)
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ResolveNode follows aliases to the node they refer to.
+func ResolveNode(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// SortedNode copies the node with the keys of all its maps sorted.
+func SortedNode(node *yaml.Node) *yaml.Node {
+	node = ResolveNode(node)
+	n := *node
+	n.Content = make([]*yaml.Node, 0, len(node.Content))
+	switch n.Kind {
+	case yaml.MappingNode:
+		type pair struct{ key, value *yaml.Node }
+		pairs := []pair{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, pair{node.Content[i], SortedNode(node.Content[i+1])})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool {
+			return pairs[i].key.Value < pairs[j].key.Value
+		})
+		for _, p := range pairs {
+			n.Content = append(n.Content, p.key, p.value)
+		}
+	default:
+		for _, c := range node.Content {
+			n.Content = append(n.Content, SortedNode(c))
+		}
+	}
+	return &n
+}
+
+// ToString marshals the node back to YAML without the comments attached to the node itself.
+func ToString(node *yaml.Node) (string, error) {
+	n := *ResolveNode(node)
+	n.HeadComment, n.LineComment, n.FootComment = "", "", ""
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&n); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	ret := strings.TrimRight(buf.String(), "\n")
+	if strings.Contains(ret, "\n") {
+		ret = fmt.Sprintf("\n%s", ret)
+	}
+	return ret, nil
+}